@@ -14,12 +14,25 @@ require filippo.io/intermediates v0.0.0-20251123024744-a07bfa91ec35
 
 require github.com/tidwall/jsonc v0.3.2
 
+// sing-box 只在 service/srs.go 中用于编译 srs，/ruleset 的 binary 格式依赖它，是否保留待维护者确认
+require github.com/sagernet/sing-box v1.12.12
+
+require (
+	github.com/miekg/dns v1.1.67 // indirect
+	github.com/sagernet/sing v0.7.13 // indirect
+	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba // indirect
+	golang.org/x/mod v0.30.0 // indirect
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/tools v0.39.0 // indirect
+)
+
 require (
 	github.com/google/wire v0.7.0
 	github.com/tidwall/match v1.2.0 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	golang.org/x/exp v0.0.0-20251113190631-e25ba8c21ef6 // indirect
-	golang.org/x/sync v0.18.0 // indirect
+	golang.org/x/sync v0.18.0
 	golang.org/x/text v0.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
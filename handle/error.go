@@ -0,0 +1,88 @@
+package handle
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/xmdhs/clash2sfa/service"
+	"github.com/xmdhs/clash2sfa/store"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+// argError 请求参数错误
+type argError struct {
+	error
+}
+
+func (a argError) Unwrap() error {
+	return a.error
+}
+
+// problem RFC 7807 错误响应
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Code     string `json:"code"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// errStatus 错误对应的状态码和错误码，错误码用于客户端判断，不要修改
+func errStatus(err error) (int, string, string) {
+	var mb *http.MaxBytesError
+	switch {
+	case errors.As(err, &mb):
+		return 413, "body_too_large", "请求体过大"
+	case errors.As(err, &argError{}):
+		return 400, "invalid_argument", "请求参数错误"
+	case errors.Is(err, service.ErrFilter):
+		return 400, "filter_regex_invalid", "过滤正则错误"
+	case errors.Is(err, service.ErrProxyGroup):
+		return 400, "proxy_group_invalid", "策略组错误"
+	case errors.Is(err, service.ErrToken):
+		return 403, "token_invalid", "token 错误"
+	case errors.Is(err, store.ErrNotFound):
+		return 404, "not_found", "不存在"
+	case errors.Is(err, service.ErrTemplate):
+		return 422, "template_invalid", "模板错误"
+	case errors.Is(err, service.ErrRuleSet):
+		return 422, "rule_set_invalid", "规则集错误"
+	case errors.Is(err, service.ErrOverlay):
+		return 422, "overlay_invalid", "补丁错误"
+	case errors.Is(err, service.ErrFormat):
+		return 400, "subscription_invalid", "订阅格式错误"
+	case errors.Is(err, service.ErrUpstream):
+		return 502, "upstream_fetch_failed", "拉取订阅失败"
+	default:
+		return 500, "internal_error", "内部错误"
+	}
+}
+
+// writeError 浏览器返回可读的文本，其他客户端返回 application/problem+json
+func writeError(w http.ResponseWriter, r *http.Request, l *slog.Logger, err error) {
+	status, code, title := errStatus(err)
+	if status >= 500 {
+		l.WarnContext(r.Context(), err.Error(), slog.String("code", code))
+	} else {
+		l.DebugContext(r.Context(), err.Error(), slog.String("code", code))
+	}
+
+	if utils.IsBrowser(r.UserAgent()) {
+		http.Error(w, title+": "+err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:     "urn:clash2sfa:error:" + code,
+		Title:    title,
+		Status:   status,
+		Detail:   err.Error(),
+		Code:     code,
+		Instance: r.URL.Path,
+	})
+}
@@ -0,0 +1,39 @@
+package handle
+
+import (
+	"net/http"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+// Explain 与 /sub 参数相同，返回每个 selector 和 urltest 的展开过程
+func (h *Handle) Explain(w http.ResponseWriter, r *http.Request) {
+	var a model.ConvertArg
+	var err error
+	if r.Method == http.MethodPost {
+		a, err = h.bodyArg(w, r)
+	} else {
+		a, err = h.formArg(r)
+	}
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
+	}
+	h.writeExplain(w, r, a)
+}
+
+func (h *Handle) writeExplain(w http.ResponseWriter, r *http.Request, a model.ConvertArg) {
+	ctx := r.Context()
+	a.RuleSetBase = baseURL(r)
+	defaultConfig, err := h.templates.Get(a.Ver)
+	if err != nil {
+		writeError(w, r, h.l, err)
+		return
+	}
+	e, err := h.convert.Explain(ctx, a, defaultConfig)
+	if err != nil {
+		writeError(w, r, h.l, err)
+		return
+	}
+	writeJson(w, 200, e)
+}
@@ -3,12 +3,16 @@ package handle
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,16 +26,24 @@ import (
 )
 
 type Handle struct {
-	convert  *service.Convert
-	l        *slog.Logger
-	configFs fs.FS
+	convert   *service.Convert
+	l         *slog.Logger
+	configFs  fs.FS
+	templates *service.Templates
+	cache     *service.ConfigCache
+	ruleSets  *service.RuleSetCache
+	stale     *service.Stale
 }
 
-func NewHandle(convert *service.Convert, l *slog.Logger, configFs fs.FS) *Handle {
+func NewHandle(convert *service.Convert, l *slog.Logger, configFs fs.FS, templates *service.Templates, cache *service.ConfigCache, ruleSets *service.RuleSetCache, stale *service.Stale) *Handle {
 	return &Handle{
-		convert:  convert,
-		l:        l,
-		configFs: configFs,
+		convert:   convert,
+		l:         l,
+		configFs:  configFs,
+		templates: templates,
+		cache:     cache,
+		ruleSets:  ruleSets,
+		stale:     stale,
 	}
 }
 
@@ -41,96 +53,208 @@ func Frontend(frontendByte []byte) http.HandlerFunc {
 	}
 }
 
-func (h *Handle) Sub(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	config := r.FormValue("config")
-	curl := r.FormValue("configurl")
-	sub := r.FormValue("sub")
-	include := r.FormValue("include")
-	exclude := r.FormValue("exclude")
-	addTag := r.FormValue("addTag")
-	disableUrlTest := r.FormValue("disableUrlTest")
-	outFields := r.FormValue("outFields")
-	enableTun := r.FormValue("enableTun")
-	proxyType := r.FormValue("proxyType")
-	proxyPort := r.FormValue("proxyPort")
-	proxyGroups := r.FormValue("proxyGroups")
-	disableUrlTestb := false
-	addTagb := false
-	enableTunb := true
+var (
+	ErrSubEmpty   = errors.New("sub 不得为空")
+	ErrProxyPort  = errors.New("proxyPort must be in range 1-65535")
+	ErrDedup      = errors.New("dedup must be first or shortest")
+	ErrTarget     = errors.New("target must be empty, clash or links")
+	ErrOverlayURL = errors.New("overlayUrl must be a http or https address")
+)
 
-	if sub == "" {
-		h.l.DebugContext(ctx, "sub 不得为空")
-		http.Error(w, "sub 不得为空", 400)
+func (h *Handle) Sub(w http.ResponseWriter, r *http.Request) {
+	a, err := h.formArg(r)
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
 		return
 	}
-	if addTag == "true" {
-		addTagb = true
+	if r.FormValue("explain") == "1" {
+		h.writeExplain(w, r, a)
+		return
 	}
-	if disableUrlTest == "true" {
-		disableUrlTestb = true
+	h.writeConfig(w, r, a)
+}
+
+// Clash 与 Sub 参数相同，输出 clash.meta 配置
+func (h *Handle) Clash(w http.ResponseWriter, r *http.Request) {
+	a, err := h.formArg(r)
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
 	}
+	a.Target = service.TargetClash
+	h.writeConfig(w, r, a)
+}
 
-	v := utils.GetSingBoxVersion(r)
-	defaultConfig := utils.GetConfig(cmodel.SING112, h.configFs)
+func (h *Handle) formArg(r *http.Request) (model.ConvertArg, error) {
+	config := r.FormValue("config")
+	proxyPort := r.FormValue("proxyPort")
+	updateInterval := r.FormValue("updateInterval")
+	proxyGroups := r.FormValue("proxyGroups")
+	outFields := r.FormValue("outFields")
+	rename := r.FormValue("rename")
+	subs := r.FormValue("subs")
+	autoRegionGroups := r.FormValue("autoRegionGroups")
+	overlay := r.FormValue("overlay")
 
-	a := model.ConvertArg{
-		Sub:            sub,
-		Include:        include,
-		Exclude:        exclude,
-		ConfigUrl:      curl,
-		AddTag:         addTagb,
-		DisableUrlTest: disableUrlTestb,
-		OutFields:      false,
-		EnableTun:      true,
-		ProxyType:      "mixed",
-		ProxyPort:      7890,
-		Ver:            v,
+	a, err := defaultArg(r)
+	if err != nil {
+		return a, err
 	}
-
-	if enableTun == "false" {
-		enableTunb = false
+	a.Sub = r.FormValue("sub")
+	a.Include = r.FormValue("include")
+	a.Exclude = r.FormValue("exclude")
+	a.ConfigUrl = r.FormValue("configurl")
+	a.AddTag = r.FormValue("addTag") == "true"
+	a.DisableUrlTest = r.FormValue("disableUrlTest") == "true"
+	a.EnableTun = r.FormValue("enableTun") != "false"
+	a.ProxyType = r.FormValue("proxyType")
+	a.OutFields = outFields == "1" || outFields == "true"
+	a.Title = r.FormValue("title")
+	a.Emoji = r.FormValue("emoji") == "true"
+	a.Dedup = r.FormValue("dedup")
+	a.ClashRules = r.FormValue("clashRules") == "true"
+	a.ClashGroups = r.FormValue("clashGroups") == "true"
+	a.ClashDNS = r.FormValue("clashDns") == "true"
+	a.Target = r.FormValue("target")
+	a.OverlayUrl = r.FormValue("overlayUrl")
+	for k, v := range r.Form {
+		if name, ok := strings.CutPrefix(k, "var."); ok && name != "" && len(v) != 0 {
+			if a.Vars == nil {
+				a.Vars = map[string]string{}
+			}
+			a.Vars[name] = v[0]
+		}
 	}
-	a.EnableTun = enableTunb
 
-	if proxyType == "mixed" || proxyType == "http" || proxyType == "socks5" {
-		a.ProxyType = proxyType
-	}
 	if proxyPort != "" {
 		var parsed int
 		_, err := fmt.Sscanf(proxyPort, "%d", &parsed)
-		if err != nil || parsed <= 0 || parsed > 65535 {
-			h.l.WarnContext(ctx, "invalid proxyPort")
-			http.Error(w, "proxyPort must be in range 1-65535", 400)
-			return
+		if err != nil {
+			return a, ErrProxyPort
 		}
 		a.ProxyPort = parsed
 	}
+	if updateInterval != "" {
+		n, err := strconv.Atoi(updateInterval)
+		if err != nil {
+			return a, fmt.Errorf("updateInterval: %w", err)
+		}
+		a.UpdateInterval = n
+	}
 	if proxyGroups != "" {
 		b, err := zlibDecode(proxyGroups)
 		if err != nil {
-			h.l.WarnContext(ctx, err.Error())
-			http.Error(w, err.Error(), 400)
-			return
+			return a, err
 		}
 		err = json.Unmarshal(b, &a.ProxyGroups)
 		if err != nil {
-			h.l.WarnContext(ctx, err.Error())
-			http.Error(w, err.Error(), 400)
-			return
+			return a, err
+		}
+	}
+	if subs != "" {
+		b, err := zlibDecode(subs)
+		if err != nil {
+			return a, err
+		}
+		err = json.Unmarshal(b, &a.Subs)
+		if err != nil {
+			return a, err
+		}
+	}
+	switch autoRegionGroups {
+	case "", "false", "0":
+	case "true", "1":
+		a.AutoRegionGroups = &model.AutoRegionGroups{}
+	default:
+		b, err := zlibDecode(autoRegionGroups)
+		if err != nil {
+			return a, err
+		}
+		err = json.Unmarshal(b, &a.AutoRegionGroups)
+		if err != nil {
+			return a, err
+		}
+	}
+	if rename != "" {
+		b, err := zlibDecode(rename)
+		if err != nil {
+			return a, err
+		}
+		err = json.Unmarshal(b, &a.Rename)
+		if err != nil {
+			return a, err
+		}
+	}
+	if config != "" {
+		b, err := zlibDecode(config)
+		if err != nil {
+			return a, err
 		}
+		a.Config = b
+	}
+	if overlay != "" {
+		b, err := zlibDecode(overlay)
+		if err != nil {
+			return a, err
+		}
+		a.Overlay = b
 	}
+	return a, h.checkArg(&a)
+}
+
+// defaultArg 返回未填写字段时使用的默认参数
+func defaultArg(r *http.Request) (model.ConvertArg, error) {
+	v, err := singBoxVer(r)
+	return model.ConvertArg{
+		EnableTun: true,
+		ProxyType: "mixed",
+		ProxyPort: 7890,
+		Ver:       v,
+	}, err
+}
 
-	if outFields == "1" || outFields == "true" {
-		a.OutFields = true
+// singBoxVer 优先使用 ver 参数，否则从 User-Agent 中识别
+func singBoxVer(r *http.Request) (cmodel.SingBoxVer, error) {
+	if v := r.URL.Query().Get("ver"); v != "" {
+		return utils.ParseSingBoxVersion(v)
+	}
+	return utils.GetSingBoxVersion(r), nil
+}
+
+// checkArg 校验参数，GET 与 POST 共用
+func (h *Handle) checkArg(a *model.ConvertArg) error {
+	if a.Sub == "" && !slices.ContainsFunc(a.Subs, func(s model.Subscription) bool {
+		return s.URL != "" && s.IsEnabled()
+	}) {
+		return ErrSubEmpty
+	}
+	if a.ProxyType != "mixed" && a.ProxyType != "http" && a.ProxyType != "socks5" {
+		a.ProxyType = "mixed"
+	}
+	if a.ProxyPort <= 0 || a.ProxyPort > 65535 {
+		return ErrProxyPort
+	}
+	if a.Dedup != "" && a.Dedup != service.DedupFirst && a.Dedup != service.DedupShortest {
+		return ErrDedup
+	}
+	if a.Target != "" && a.Target != service.TargetClash && a.Target != service.TargetLinks {
+		return ErrTarget
+	}
+	if a.OverlayUrl != "" && !strings.HasPrefix(a.OverlayUrl, "http://") && !strings.HasPrefix(a.OverlayUrl, "https://") {
+		return ErrOverlayURL
 	}
 
+	// 本地模板的优先级低于 config 参数，远程模板的优先级高于 config，与之前的行为一致
+	if a.ConfigUrl != "" && !strings.HasPrefix(a.ConfigUrl, "http") && len(a.Config) != 0 {
+		a.ConfigUrl = ""
+	}
 	if a.ConfigUrl != "" && !strings.HasPrefix(a.ConfigUrl, "http") {
 		b, err := func() ([]byte, error) {
 			f, err := h.configFs.Open(a.ConfigUrl)
 			if err != nil {
 				return nil, err
 			}
+			defer f.Close()
 			b, err := io.ReadAll(f)
 			if err != nil {
 				return nil, err
@@ -138,34 +262,109 @@ func (h *Handle) Sub(w http.ResponseWriter, r *http.Request) {
 			return b, nil
 		}()
 		if err != nil {
-			h.l.WarnContext(ctx, err.Error())
-			http.Error(w, err.Error(), 400)
-			return
+			return err
 		}
 		a.Config = b
 		a.ConfigUrl = ""
 	}
+	return nil
+}
 
-	rc := http.NewResponseController(w)
-	rc.SetWriteDeadline(time.Now().Add(2 * time.Minute))
+func (h *Handle) writeConfig(w http.ResponseWriter, r *http.Request, a model.ConvertArg) {
+	ctx := r.Context()
+	if a.Target != "" {
+		// 其他格式不受 sing-box 版本影响，使用最新的模板和格式
+		a.Ver = cmodel.SINGLATEST
+	}
+	a.RuleSetBase = baseURL(r)
+	key := service.ArgKey(a, r.UserAgent())
 
-	b, err := func() ([]byte, error) {
-		if config != "" {
-			b, err := zlibDecode(config)
-			if err != nil {
-				return nil, err
+	c, ok := h.cache.Get(key)
+	if ok && r.URL.Query().Get("nocache") != "1" {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+		defaultConfig, err := h.templates.Get(a.Ver)
+		if err != nil {
+			writeError(w, r, h.l, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Now().Add(2 * time.Minute))
+
+		nc, err := h.convert.MakeConfig(ctx, a, defaultConfig, r.UserAgent())
+		if err != nil {
+			sc, ok := h.loadStale(ctx, key, err)
+			if !ok {
+				writeError(w, r, h.l, err)
+				return
 			}
-			a.Config = b
+			h.l.WarnContext(ctx, err.Error())
+			c = sc
+			w.Header().Set("X-Cache", "STALE")
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			w.Header().Set("X-Clash2sfa-Stale-Since", c.Time.UTC().Format(http.TimeFormat))
+		} else {
+			c = nc
+			h.cache.Set(key, c)
+			h.saveStale(ctx, key, c)
 		}
-		return h.convert.MakeConfig(ctx, a, defaultConfig, r.UserAgent())
-	}()
+	}
+
+	c.SubInfo.WriteHeader(w.Header())
+	for _, v := range c.Warnings {
+		w.Header().Add("X-Clash2sfa-Warning", headerValue(v))
+	}
+	switch a.Target {
+	case service.TargetClash:
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	case service.TargetLinks:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("ETag", c.ETag)
+	if etagMatch(r.Header.Get("If-None-Match"), c.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(c.Body)
+}
+
+func (h *Handle) loadStale(ctx context.Context, key string, err error) (model.ConfigResult, bool) {
+	if h.stale == nil || !errors.Is(err, service.ErrUpstream) {
+		return model.ConfigResult{}, false
+	}
+	return h.stale.Load(ctx, key)
+}
+
+func (h *Handle) saveStale(ctx context.Context, key string, c model.ConfigResult) {
+	if h.stale == nil {
+		return
+	}
+	err := h.stale.Save(ctx, key, c)
 	if err != nil {
 		h.l.WarnContext(ctx, err.Error())
-		http.Error(w, err.Error(), 500)
-		return
 	}
-	w.Write(b)
+}
+
+// headerValue 响应头中只能放 ascii，其他字符进行转义
+func headerValue(s string) string {
+	// 只去掉 QuoteToASCII 首尾的引号，内容末尾转义的引号需要保留
+	q := strconv.QuoteToASCII(s)
+	return q[1 : len(q)-1]
+}
 
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func zlibDecode(s string) ([]byte, error) {
@@ -0,0 +1,31 @@
+package handle
+
+import (
+	"net/http"
+)
+
+// Nodes 列出订阅中的节点，参数与 /sub 相同，format=outbounds 时只返回 outbounds 数组
+func (h *Handle) Nodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	a, err := h.formArg(r)
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
+	}
+	defaultConfig, err := h.templates.Get(a.Ver)
+	if err != nil {
+		writeError(w, r, h.l, err)
+		return
+	}
+
+	nodes, outbounds, err := h.convert.Nodes(ctx, a, defaultConfig)
+	if err != nil {
+		writeError(w, r, h.l, err)
+		return
+	}
+	if r.FormValue("format") == "outbounds" {
+		writeJson(w, 200, outbounds)
+		return
+	}
+	writeJson(w, 200, nodes)
+}
@@ -0,0 +1,38 @@
+package handle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+// maxBodySize POST 请求体的大小上限，与拉取远程模板时的限制一致
+const maxBodySize = 1000 * 1000 * 10
+
+// SubPost 从 json 请求体中读取转换参数，适用于 url 过长的大模板
+func (h *Handle) SubPost(w http.ResponseWriter, r *http.Request) {
+	a, err := h.bodyArg(w, r)
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
+	}
+	if r.URL.Query().Get("explain") == "1" {
+		h.writeExplain(w, r, a)
+		return
+	}
+	h.writeConfig(w, r, a)
+}
+
+func (h *Handle) bodyArg(w http.ResponseWriter, r *http.Request) (model.ConvertArg, error) {
+	a, err := defaultArg(r)
+	if err != nil {
+		return a, fmt.Errorf("bodyArg: %w", err)
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&a)
+	if err != nil {
+		return a, fmt.Errorf("bodyArg: %w", err)
+	}
+	return a, h.checkArg(&a)
+}
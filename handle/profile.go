@@ -0,0 +1,127 @@
+package handle
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xmdhs/clash2sfa/service"
+)
+
+type ProfileHandle struct {
+	h       *Handle
+	profile *service.Profile
+	l       *slog.Logger
+}
+
+func NewProfileHandle(h *Handle, profile *service.Profile, l *slog.Logger) *ProfileHandle {
+	return &ProfileHandle{
+		h:       h,
+		profile: profile,
+		l:       l,
+	}
+}
+
+type profileResp struct {
+	ID    string `json:"id"`
+	Token string `json:"token,omitempty"`
+	URL   string `json:"url"`
+}
+
+// maxProfileSize 保存的参数的大小上限，比直接转换时小，避免占用过多存储
+const maxProfileSize = 1000 * 1000
+
+func (p *ProfileHandle) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, maxProfileSize)
+	a, err := p.h.bodyArg(w, r)
+	if err != nil {
+		writeError(w, r, p.l, argError{err})
+		return
+	}
+	id, token, err := p.profile.Create(ctx, a)
+	if err != nil {
+		writeError(w, r, p.l, err)
+		return
+	}
+	writeJson(w, 201, profileResp{
+		ID:    id,
+		Token: token,
+		URL:   shortURL(r, id),
+	})
+}
+
+func (p *ProfileHandle) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	r.Body = http.MaxBytesReader(w, r.Body, maxProfileSize)
+	a, err := p.h.bodyArg(w, r)
+	if err != nil {
+		writeError(w, r, p.l, argError{err})
+		return
+	}
+	err = p.profile.Update(ctx, id, editToken(r), a)
+	if err != nil {
+		writeError(w, r, p.l, err)
+		return
+	}
+	writeJson(w, 200, profileResp{
+		ID:  id,
+		URL: shortURL(r, id),
+	})
+}
+
+func (p *ProfileHandle) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := p.profile.Delete(r.Context(), id, editToken(r))
+	if err != nil {
+		writeError(w, r, p.l, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Sub 使用保存的参数生成配置，sing-box 版本仍然由本次请求的 User-Agent 决定
+func (p *ProfileHandle) Sub(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	a, err := p.profile.Get(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, p.l, err)
+		return
+	}
+	a.Ver, err = singBoxVer(r)
+	if err != nil {
+		writeError(w, r, p.l, argError{err})
+		return
+	}
+	p.h.writeConfig(w, r, a)
+}
+
+// editToken 从 Authorization: Bearer 或 token 参数中读取
+func editToken(r *http.Request) string {
+	if after, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(after)
+	}
+	return r.FormValue("token")
+}
+
+func shortURL(r *http.Request, id string) string {
+	return baseURL(r) + "/s/" + id
+}
+
+// baseURL 客户端访问本服务使用的地址
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,77 @@
+package handle
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xmdhs/clash2sfa/service"
+	"github.com/xmdhs/clash2sfa/store"
+)
+
+func TestProfileHandle(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := slog.Default()
+	p := NewProfileHandle(NewHandle(nil, l, nil, nil, nil, nil, nil), service.NewProfile(s), l)
+	mux := chi.NewMux()
+	mux.Get("/s/{id}", p.Sub)
+	mux.Post("/api/profiles", p.Create)
+	mux.Put("/api/profiles/{id}", p.Update)
+	mux.Delete("/api/profiles/{id}", p.Delete)
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/api/profiles", "", `{"sub":"https://a.com/sub"}`)
+	if w.Code != 201 {
+		t.Fatalf("create: status = %d: %s", w.Code, w.Body)
+	}
+	var resp profileResp
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID == "" || resp.Token == "" || resp.URL != "http://example.com/s/"+resp.ID {
+		t.Fatalf("create: %+v", resp)
+	}
+	path := "/api/profiles/" + resp.ID
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "create without sub", method: "POST", target: "/api/profiles", body: `{}`, status: 400},
+		{name: "create too large", method: "POST", target: "/api/profiles", body: `{"sub":"` + strings.Repeat("a", maxProfileSize) + `"}`, status: 413},
+		{name: "update without token", method: "PUT", target: path, body: `{"sub":"https://b.com/sub"}`, status: 403},
+		{name: "update wrong token", method: "PUT", target: path, token: "x", body: `{"sub":"https://b.com/sub"}`, status: 403},
+		{name: "update missing", method: "PUT", target: "/api/profiles/missing", token: resp.Token, body: `{"sub":"https://b.com/sub"}`, status: 404},
+		{name: "update", method: "PUT", target: path, token: resp.Token, body: `{"sub":"https://b.com/sub"}`, status: 200},
+		{name: "delete wrong token", method: "DELETE", target: path, token: "x", status: 403},
+		{name: "delete", method: "DELETE", target: path, token: resp.Token, status: 204},
+		{name: "delete again", method: "DELETE", target: path, token: resp.Token, status: 404},
+		{name: "sub missing", method: "GET", target: "/s/" + resp.ID, status: 404},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.target, tt.token, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+}
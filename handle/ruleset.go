@@ -0,0 +1,63 @@
+package handle
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/xmdhs/clash2sfa/service"
+)
+
+var (
+	ErrRuleSetURL    = errors.New("url must be a http or https address")
+	ErrRuleSetOption = errors.New("behavior must be classical, domain or ipcidr, format must be source or binary")
+)
+
+// RuleSet 把 url 中 clash 的 rule-provider 编译为 sing-box 规则集，结果使用单独的缓存，
+// 可以直接作为 proxyGroups 的 srsUrl。behavior 默认为 classical，format 默认为 binary
+func (h *Handle) RuleSet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := r.FormValue("url")
+	behavior := strings.ToLower(cmp.Or(r.FormValue("behavior"), "classical"))
+	format := cmp.Or(r.FormValue("format"), service.RuleSetBinary)
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		writeError(w, r, h.l, argError{ErrRuleSetURL})
+		return
+	}
+	if (behavior != "classical" && behavior != "domain" && behavior != "ipcidr") ||
+		(format != service.RuleSetSource && format != service.RuleSetBinary) {
+		writeError(w, r, h.l, argError{ErrRuleSetOption})
+		return
+	}
+
+	key := service.RuleSetKey(u, behavior, format)
+	c, ok := h.ruleSets.Get(key)
+	if ok && r.URL.Query().Get("nocache") != "1" {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+		var err error
+		c, err = h.convert.CompileRuleSet(ctx, u, behavior, format)
+		if err != nil {
+			writeError(w, r, h.l, err)
+			return
+		}
+		h.ruleSets.Set(key, c)
+	}
+
+	for _, v := range c.Warnings {
+		w.Header().Add("X-Clash2sfa-Warning", headerValue(v))
+	}
+	if format == service.RuleSetBinary {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Header().Set("ETag", c.ETag)
+	if etagMatch(r.Header.Get("If-None-Match"), c.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(c.Body)
+}
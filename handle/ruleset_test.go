@@ -0,0 +1,85 @@
+package handle
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/service"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+func TestRuleSet(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rules" {
+			w.Write([]byte("DOMAIN-SUFFIX,a.com\nUSER-AGENT,curl\n"))
+			return
+		}
+		w.Write([]byte("payload:\n  - '+.a.com'\n"))
+	}))
+	defer up.Close()
+	l := slog.Default()
+	h := NewHandle(service.NewConvert(up.Client(), l), l, nil, nil, nil,
+		&service.RuleSetCache{Cache: utils.NewCache[model.ConfigResult](10, time.Minute)}, nil)
+
+	get := func(q url.Values, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/ruleset?"+q.Encode(), nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.RuleSet(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name        string
+		query       url.Values
+		status      int
+		contentType string
+		cache       string
+	}{
+		{name: "not http", query: url.Values{"url": {"file:///etc/passwd"}}, status: 400},
+		{name: "bad behavior", query: url.Values{"url": {up.URL}, "behavior": {"other"}}, status: 400},
+		{name: "bad format", query: url.Values{"url": {up.URL}, "format": {"yaml"}}, status: 400},
+		{name: "binary", query: url.Values{"url": {up.URL}, "behavior": {"domain"}}, status: 200, contentType: "application/octet-stream", cache: "MISS"},
+		{name: "cached", query: url.Values{"url": {up.URL}, "behavior": {"domain"}}, status: 200, contentType: "application/octet-stream", cache: "HIT"},
+		{name: "source", query: url.Values{"url": {up.URL + "/rules"}, "format": {"source"}}, status: 200, contentType: "application/json; charset=utf-8", cache: "MISS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.query, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != 200 {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("content-type = %q, want %q", ct, tt.contentType)
+			}
+			if c := w.Header().Get("X-Cache"); c != tt.cache {
+				t.Errorf("x-cache = %q, want %q", c, tt.cache)
+			}
+			if w.Body.Len() == 0 || w.Header().Get("ETag") == "" {
+				t.Error("empty body or etag")
+			}
+		})
+	}
+
+	t.Run("warning and etag", func(t *testing.T) {
+		q := url.Values{"url": {up.URL + "/rules"}, "nocache": {"1"}}
+		w := get(q, nil)
+		if w.Code != 200 || w.Header().Get("X-Clash2sfa-Warning") == "" {
+			t.Fatalf("status = %d, warning = %q", w.Code, w.Header().Get("X-Clash2sfa-Warning"))
+		}
+		w = get(q, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("status = %d, want 304", w.Code)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package handle
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/xmdhs/clash2sfa/service"
+)
+
+type migrateResp struct {
+	Template map[string]any `json:"template"`
+	Changes  []string       `json:"changes"`
+}
+
+// MigrateTemplate 请求体为旧模板，升级为 ver 参数或 User-Agent 对应的版本
+func (h *Handle) MigrateTemplate(w http.ResponseWriter, r *http.Request) {
+	v, err := singBoxVer(r)
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, r, h.l, argError{err})
+		return
+	}
+	m, changes, err := service.MigrateTemplate(b, v)
+	if err != nil {
+		writeError(w, r, h.l, err)
+		return
+	}
+	writeJson(w, 200, migrateResp{
+		Template: m,
+		Changes:  changes,
+	})
+}
@@ -1,23 +1,45 @@
 package model
 
 import (
+	"encoding/json"
+
 	"github.com/xmdhs/clash2singbox/model"
 )
 
 type ConvertArg struct {
-	Sub            string
-	Include        string
-	Exclude        string
-	ProxyGroups    []ProxyGroup
-	Config         []byte
-	ConfigUrl      string
-	AddTag         bool
-	DisableUrlTest bool
-	OutFields      bool
-	EnableTun      bool
-	ProxyType      string
-	ProxyPort      int
-	Ver            model.SingBoxVer
+	Sub              string            `json:"sub"`
+	Subs             []Subscription    `json:"subs"`
+	Include          string            `json:"include"`
+	Exclude          string            `json:"exclude"`
+	ProxyGroups      []ProxyGroup      `json:"proxyGroups"`
+	Config           Template          `json:"config"`
+	ConfigUrl        string            `json:"configurl"`
+	AddTag           bool              `json:"addTag"`
+	DisableUrlTest   bool              `json:"disableUrlTest"`
+	OutFields        bool              `json:"outFields"`
+	EnableTun        bool              `json:"enableTun"`
+	ProxyType        string            `json:"proxyType"`
+	ProxyPort        int               `json:"proxyPort"`
+	Title            string            `json:"title"`
+	UpdateInterval   int               `json:"updateInterval"`
+	Rename           []RenameRule      `json:"rename"`
+	Emoji            bool              `json:"emoji"`
+	Dedup            string            `json:"dedup"`
+	AutoRegionGroups *AutoRegionGroups `json:"autoRegionGroups"`
+	ClashRules       bool              `json:"clashRules"`
+	ClashGroups      bool              `json:"clashGroups"`
+	ClashDNS         bool              `json:"clashDns"`
+	// Vars 模板中通过 .Param 读取的参数，GET 时为 var.* 参数
+	Vars map[string]string `json:"vars"`
+	// Overlay 最后应用到配置上的补丁，json 对象为 merge patch，数组为 json patch，
+	// 与 Config 相同可以是字符串或直接是 json。OverlayUrl 不为空时从链接中读取
+	Overlay    Template `json:"overlay"`
+	OverlayUrl string   `json:"overlayUrl"`
+	// Target 输出的格式，为空时输出 sing-box 配置
+	Target string           `json:"target"`
+	Ver    model.SingBoxVer `json:"-"`
+	// RuleSetBase 本服务的地址，clash 的 rule-provider 通过其中的 /ruleset 转换为远程规则集
+	RuleSetBase string `json:"-"`
 }
 
 type ProxyGroup struct {
@@ -26,4 +48,115 @@ type ProxyGroup struct {
 	Include string `json:"include"`
 	Exclude string `json:"exclude"`
 	SrsURL  string `json:"srsUrl"`
+	// Outbounds 固定的成员，可以是其他策略组或模板中的出站
+	Outbounds []string `json:"outbounds"`
+	// Default selector 默认选中的出站
+	Default string `json:"default"`
+
+	// 以下只对 urltest 生效，为空时使用默认值
+	URL         string `json:"url"`
+	Interval    string `json:"interval"`
+	Tolerance   int    `json:"tolerance"`
+	IdleTimeout string `json:"idleTimeout"`
+
+	InterruptExistConnections bool `json:"interruptExistConnections"`
+
+	// 以下为分流到此策略组的规则，SrsURL 也会加入 RuleSets
+	RuleSets      []GroupRuleSet `json:"ruleSets"`
+	Domain        []string       `json:"domain"`
+	DomainSuffix  []string       `json:"domainSuffix"`
+	DomainKeyword []string       `json:"domainKeyword"`
+	IPCIDR        []string       `json:"ipCidr"`
+	ProcessName   []string       `json:"processName"`
+	// DownloadDetour 与 UpdateInterval 用于此策略组的远程规则集
+	DownloadDetour string `json:"downloadDetour"`
+	UpdateInterval string `json:"updateInterval"`
+	// Priority 规则插入模板 route.rules 的位置，按模板原有的规则计算，负数从末尾计算，为 nil 时插入到直连兜底规则之前
+	Priority *int `json:"priority"`
+	// DNSServer 匹配的域名使用的 dns 服务器，为空时使用 detour 为此策略组或第一个经过代理的服务器，后者会给出警告
+	DNSServer string `json:"dnsServer"`
+}
+
+// GroupRuleSet 策略组的规则集，只填写 Tag 时引用模板中已有的规则集
+type GroupRuleSet struct {
+	Tag string `json:"tag"`
+	// Type remote 或 inline，为空时有 URL 视为 remote
+	Type string `json:"type"`
+	// Format binary 或 source，为空时根据 URL 后缀判断
+	Format string           `json:"format"`
+	URL    string           `json:"url"`
+	Rules  []map[string]any `json:"rules"`
+}
+
+// Subscription 多订阅时单个订阅的设置
+type Subscription struct {
+	URL string `json:"url"`
+	// Label 不为空时加在节点名称后，代替 addTag 使用的域名
+	Label     string       `json:"label"`
+	Include   string       `json:"include"`
+	Exclude   string       `json:"exclude"`
+	Rename    []RenameRule `json:"rename"`
+	UserAgent string       `json:"userAgent"`
+	Enabled   *bool        `json:"enabled"`
+}
+
+// IsEnabled 未填写 enabled 时视为启用
+func (s Subscription) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// AutoRegionGroups 自动地区策略组的设置，为 nil 时不生成
+type AutoRegionGroups struct {
+	// Order 地区代码，排在前面的策略组优先，未列出的按内置顺序或节点数排在后面
+	Order []string `json:"order"`
+	// SortByCount 未在 Order 中的地区按节点数从多到少排列
+	SortByCount bool `json:"sortByCount"`
+	// MinNodes 节点数少于此值的地区不生成策略组
+	MinNodes int `json:"minNodes"`
+	// Regions 覆盖或增加内置的地区，Code 与内置相同时替换内置的名称和匹配规则
+	Regions []Region `json:"regions"`
+	// URL、Interval 和 Tolerance 用于生成的 urltest，为空时使用模板中第一个 urltest 的设置
+	URL       string `json:"url"`
+	Interval  string `json:"interval"`
+	Tolerance int    `json:"tolerance"`
+}
+
+type Region struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Match string `json:"match"`
+}
+
+// RenameRule 节点重命名规则，Replace 中可使用 $1 引用分组
+type RenameRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// Template 配置文件模板，json 中可以是字符串（支持 jsonc）也可以直接是对象
+type Template []byte
+
+func (t *Template) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+		*t = Template(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*t = nil
+		return nil
+	}
+	*t = append((*t)[:0], b...)
+	return nil
+}
+
+func (t Template) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(string(t))
 }
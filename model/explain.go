@@ -0,0 +1,30 @@
+package model
+
+// Explain 每个策略组 include/exclude 的展开结果
+type Explain struct {
+	Include  string        `json:"include,omitempty"`
+	Exclude  string        `json:"exclude,omitempty"`
+	Groups   []GroupReport `json:"groups"`
+	Dedup    []DedupReport `json:"dedup,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
+// DedupReport 一组重复节点中保留和去掉的节点
+type DedupReport struct {
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+type GroupReport struct {
+	Tag        string   `json:"tag"`
+	Type       string   `json:"type"`
+	Directives []string `json:"directives,omitempty"`
+	Include    string   `json:"include,omitempty"`
+	Exclude    string   `json:"exclude,omitempty"`
+	Matched    []string `json:"matched,omitempty"`
+	Excluded   []string `json:"excluded,omitempty"`
+	// DetourTags 只有通过 detour 链才可见的节点
+	DetourTags []string `json:"detourTags,omitempty"`
+	Outbounds  []string `json:"outbounds"`
+	Empty      bool     `json:"empty"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// Node 订阅中解析出的节点，用于 /api/nodes
+type Node struct {
+	Tag     string `json:"tag"`
+	Type    string `json:"type"`
+	Server  string `json:"server"`
+	Port    int    `json:"port"`
+	Sub     string `json:"sub"`
+	Ignored bool   `json:"ignored"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Profile 保存的订阅参数，通过短链接 /s/{id} 访问
+type Profile struct {
+	ID        string     `json:"id"`
+	TokenHash string     `json:"tokenHash"`
+	Arg       ConvertArg `json:"arg"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// ConfigResult 生成的配置，用于缓存和上游出错时返回旧配置
+type ConfigResult struct {
+	Body     []byte    `json:"body"`
+	SubInfo  SubInfo   `json:"subInfo"`
+	Warnings []string  `json:"warnings"`
+	ETag     string    `json:"etag"`
+	Time     time.Time `json:"time"`
+}
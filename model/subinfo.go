@@ -0,0 +1,99 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SubInfo 机场订阅返回的流量、到期时间等信息
+type SubInfo struct {
+	HasUserInfo        bool
+	Upload             int64
+	Download           int64
+	Total              int64
+	Expire             int64
+	UpdateInterval     int
+	Title              string
+	ContentDisposition string
+}
+
+// Add 合并多个订阅的信息，流量相加，到期时间取最早的
+func (s *SubInfo) Add(h http.Header) {
+	if v := h.Get("subscription-userinfo"); v != "" {
+		var upload, download, total, expire int64
+		for _, kv := range strings.Split(v, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(k)) {
+			case "upload":
+				upload = int64(n)
+			case "download":
+				download = int64(n)
+			case "total":
+				total = int64(n)
+			case "expire":
+				expire = int64(n)
+			}
+		}
+		s.HasUserInfo = true
+		s.Upload += upload
+		s.Download += download
+		s.Total += total
+		if expire > 0 && (s.Expire == 0 || expire < s.Expire) {
+			s.Expire = expire
+		}
+	}
+	if v := h.Get("profile-update-interval"); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil && n > 0 && (s.UpdateInterval == 0 || n < s.UpdateInterval) {
+			s.UpdateInterval = n
+		}
+	}
+	if s.Title == "" {
+		s.Title = h.Get("profile-title")
+	}
+	if s.ContentDisposition == "" {
+		s.ContentDisposition = h.Get("content-disposition")
+	}
+}
+
+// SetTitle 覆盖订阅名称，包含非 ascii 或控制字符的名称使用 base64 编码
+func (s *SubInfo) SetTitle(title string) {
+	s.Title = title
+	if strings.ContainsFunc(title, func(r rune) bool {
+		return r > unicode.MaxASCII || unicode.IsControl(r)
+	}) {
+		s.Title = "base64:" + base64.StdEncoding.EncodeToString([]byte(title))
+	}
+	s.ContentDisposition = "attachment; filename*=UTF-8''" + url.PathEscape(title)
+}
+
+func (s SubInfo) WriteHeader(h http.Header) {
+	if s.HasUserInfo {
+		v := fmt.Sprintf("upload=%d; download=%d; total=%d", s.Upload, s.Download, s.Total)
+		if s.Expire > 0 {
+			v += fmt.Sprintf("; expire=%d", s.Expire)
+		}
+		h.Set("subscription-userinfo", v)
+	}
+	if s.UpdateInterval > 0 {
+		h.Set("profile-update-interval", strconv.Itoa(s.UpdateInterval))
+	}
+	if s.Title != "" {
+		h.Set("profile-title", s.Title)
+	}
+	if s.ContentDisposition != "" {
+		h.Set("content-disposition", s.ContentDisposition)
+	}
+}
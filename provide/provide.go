@@ -10,7 +10,9 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"text/template"
 	"time"
 
@@ -20,7 +22,10 @@ import (
 	"github.com/google/wire"
 	"github.com/samber/lo"
 	"github.com/xmdhs/clash2sfa/handle"
+	"github.com/xmdhs/clash2sfa/model"
 	"github.com/xmdhs/clash2sfa/service"
+	"github.com/xmdhs/clash2sfa/store"
+	"github.com/xmdhs/clash2sfa/utils"
 )
 
 //go:embed static
@@ -29,7 +34,7 @@ var static embed.FS
 //go:embed frontend.html
 var FrontendByte []byte
 
-var All = wire.NewSet(NewSlog, NewClient, SetMux, NewHttpServer)
+var All = wire.NewSet(NewSlog, NewClient, NewProfileStore, NewTemplates, NewConfigCache, NewRuleSetCache, NewStale, SetMux, NewHttpServer)
 
 func NewClient() *http.Client {
 	tr := http.DefaultTransport.(*http.Transport).Clone()
@@ -43,6 +48,82 @@ func NewClient() *http.Client {
 	}
 }
 
+// NewProfileStore 短链接保存目录，可通过环境变量 profile_dir 修改
+func NewProfileStore(l *slog.Logger) (store.ProfileStore, error) {
+	dir := "profiles"
+	if d := os.Getenv("profile_dir"); d != "" {
+		dir = d
+	} else if os.Getenv("VERCEL") != "" {
+		// vercel 上只有临时目录可写
+		dir = filepath.Join(os.TempDir(), "profiles")
+		l.Warn("profiles are stored in a temporary directory and will be lost when the instance restarts, set profile_dir to a persistent directory", slog.String("dir", dir))
+	}
+	return store.NewFileStore(dir)
+}
+
+// NewTemplates 内置的默认模板，可以在环境变量 template_dir 指定的目录中放置同名文件覆盖
+func NewTemplates() *service.Templates {
+	return service.NewTemplates(lo.Must(fs.Sub(static, "static")), os.Getenv("template_dir"))
+}
+
+// NewConfigCache 生成结果的缓存，通过环境变量 cache_ttl（如 5m，0 为关闭）和 cache_size 修改
+func NewConfigCache() (*service.ConfigCache, error) {
+	c, err := newCache("cache", 5*time.Minute, 200)
+	if err != nil {
+		return nil, fmt.Errorf("NewConfigCache: %w", err)
+	}
+	return c, nil
+}
+
+// NewRuleSetCache /ruleset 的缓存，规则集很少变化，通过环境变量 ruleset_cache_ttl 和 ruleset_cache_size 修改
+func NewRuleSetCache() (*service.RuleSetCache, error) {
+	c, err := newCache("ruleset_cache", time.Hour, 50)
+	if err != nil {
+		return nil, fmt.Errorf("NewRuleSetCache: %w", err)
+	}
+	return &service.RuleSetCache{Cache: c}, nil
+}
+
+func newCache(env string, ttl time.Duration, size int) (*utils.Cache[model.ConfigResult], error) {
+	if v := os.Getenv(env + "_ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("newCache: %w", err)
+		}
+		ttl = d
+	}
+	if v := os.Getenv(env + "_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("newCache: %w", err)
+		}
+		size = n
+	}
+	return utils.NewCache[model.ConfigResult](size, ttl), nil
+}
+
+// NewStale 设置环境变量 stale_dir 后开启，上游订阅出错时返回保存的旧配置，
+// 旧配置的最长保留时间通过 stale_max_age 修改
+func NewStale() (*service.Stale, error) {
+	dir := os.Getenv("stale_dir")
+	if dir == "" {
+		return nil, nil
+	}
+	maxAge := 72 * time.Hour
+	if v := os.Getenv("stale_max_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("NewStale: %w", err)
+		}
+		maxAge = d
+	}
+	s, err := store.NewFileStale(dir)
+	if err != nil {
+		return nil, fmt.Errorf("NewStale: %w", err)
+	}
+	return service.NewStale(s, maxAge), nil
+}
+
 func NewHttpServer(m *chi.Mux) http.Handler {
 	return m
 }
@@ -81,10 +162,11 @@ func init() {
 	}
 }
 
-func SetMux(h slog.Handler, c *http.Client, l *slog.Logger) *chi.Mux {
+func SetMux(h slog.Handler, c *http.Client, l *slog.Logger, ps store.ProfileStore, templates *service.Templates, cache *service.ConfigCache, ruleSets *service.RuleSetCache, stale *service.Stale) *chi.Mux {
 	static := lo.Must(fs.Sub(static, "static"))
 	convert := service.NewConvert(c, l)
-	subH := handle.NewHandle(convert, l, static)
+	subH := handle.NewHandle(convert, l, static, templates, cache, ruleSets, stale)
+	profileH := handle.NewProfileHandle(subH, service.NewProfile(ps), l)
 
 	mux := chi.NewMux()
 
@@ -93,6 +175,19 @@ func SetMux(h slog.Handler, c *http.Client, l *slog.Logger) *chi.Mux {
 	mux.Use(NewStructuredLogger(l))
 
 	mux.Get("/sub", subH.Sub)
+	mux.Post("/sub", subH.SubPost)
+	mux.Get("/clash", subH.Clash)
+	mux.Get("/ruleset", subH.RuleSet)
+	mux.Post("/api/convert", subH.SubPost)
+	mux.Get("/api/nodes", subH.Nodes)
+	mux.Get("/api/explain", subH.Explain)
+	mux.Post("/api/explain", subH.Explain)
+	mux.Post("/api/template/migrate", subH.MigrateTemplate)
+
+	mux.Get("/s/{id}", profileH.Sub)
+	mux.Post("/api/profiles", profileH.Create)
+	mux.Put("/api/profiles/{id}", profileH.Update)
+	mux.Delete("/api/profiles/{id}", profileH.Delete)
 
 	mux.With(Cache).Mount("/config", http.StripPrefix("/config", http.FileServerFS(static)))
 	mux.With(Cache).Mount("/static", http.StripPrefix("/static", http.FileServerFS(static)))
@@ -139,11 +234,15 @@ type StructuredLoggerEntry struct {
 }
 
 func (l *StructuredLoggerEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
-	l.Logger.LogAttrs(l.ctx, slog.LevelDebug, "request complete",
+	attrs := []slog.Attr{
 		slog.Int("resp_status", status),
 		slog.Int("resp_byte_length", bytes),
 		slog.Float64("resp_elapsed_ms", float64(elapsed.Nanoseconds())/1000000.0),
-	)
+	}
+	if c := header.Get("X-Cache"); c != "" {
+		attrs = append(attrs, slog.String("cache", c))
+	}
+	l.Logger.LogAttrs(l.ctx, slog.LevelDebug, "request complete", attrs...)
 }
 
 func (l *StructuredLoggerEntry) Panic(v interface{}, stack []byte) {
@@ -16,7 +16,24 @@ import (
 func InitializeServer(h slog.Handler) (http.Handler, func(), error) {
 	client := NewClient()
 	logger := NewSlog(h)
-	mux := SetMux(h, client, logger)
+	profileStore, err := NewProfileStore(logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	templates := NewTemplates()
+	v, err := NewConfigCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	ruleSetCache, err := NewRuleSetCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	stale, err := NewStale()
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := SetMux(h, client, logger, profileStore, templates, v, ruleSetCache, stale)
 	handler := NewHttpServer(mux)
 	return handler, func() {
 	}, nil
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"log/slog"
 
@@ -32,149 +33,182 @@ func NewConvert(c *http.Client, l *slog.Logger) *Convert {
 	}
 }
 
-func (c *Convert) MakeConfig(cxt context.Context, arg model.ConvertArg, configByte []byte, userAgent string) ([]byte, error) {
-	if arg.Config == nil && arg.ConfigUrl == "" {
-		arg.Config = configByte
-	}
-	if arg.ConfigUrl != "" {
-		b, err := httputils.HttpGet(cxt, c.c, arg.ConfigUrl, 1000*1000*10)
-		if err != nil {
-			return nil, fmt.Errorf("MakeConfig: %w", err)
-		}
-		arg.Config = b
-	}
-	// 支持 jsonc
-	m, nodeTag, err := convert2sing(cxt, c.c, jsonc.ToJSON(arg.Config), arg.Sub, arg.Include, arg.Exclude, arg.AddTag, c.l, !arg.DisableUrlTest, arg.OutFields, arg.Ver)
-	if err != nil {
-		return nil, fmt.Errorf("MakeConfig: %w", err)
-	}
-	m = applyProxyGroups(m, arg.ProxyGroups)
-	m = applyInboundSettings(m, arg.EnableTun, arg.ProxyType, arg.ProxyPort)
-	m, err = configUrlTestParser(m, nodeTag)
+// 输出的格式，为空时为 sing-box
+const (
+	TargetClash = "clash"
+	TargetLinks = "links"
+)
+
+func (c *Convert) MakeConfig(cxt context.Context, arg model.ConvertArg, configByte []byte, userAgent string) (model.ConfigResult, error) {
+	mr, err := c.makeMap(cxt, arg, configByte)
 	if err != nil {
-		return nil, fmt.Errorf("MakeConfig: %w", err)
+		return model.ConfigResult{}, fmt.Errorf("MakeConfig: %w", err)
 	}
+	m := mr.config
 
 	// 根据 User-Agent 决定是否格式化 JSON
 	var result []byte
-	if utils.IsBrowser(userAgent) {
+	var w []string
+	switch {
+	case arg.Target == TargetClash:
+		result, w, err = clashYAML(m, arg)
+	case arg.Target == TargetLinks:
+		result, w, err = shareLinks(m, mr.nodeTag, arg.Include, arg.Exclude)
+	case utils.IsBrowser(userAgent):
 		// 浏览器请求，返回格式化的 JSON
 		bw := &bytes.Buffer{}
 		jw := json.NewEncoder(bw)
 		jw.SetIndent("", "    ")
 		err = jw.Encode(m)
-		if err != nil {
-			return nil, fmt.Errorf("MakeConfig: %w", err)
-		}
 		result = bw.Bytes()
-	} else {
+	default:
 		// 非浏览器请求，返回压缩的 JSON
 		result, err = json.Marshal(m)
-		if err != nil {
-			return nil, fmt.Errorf("MakeConfig: %w", err)
-		}
 	}
+	if err != nil {
+		return model.ConfigResult{}, fmt.Errorf("MakeConfig: %w", err)
+	}
+	warnings := append(mr.warnings, w...)
 
-	return result, nil
+	subInfo := mr.subInfo
+	if arg.Title != "" {
+		subInfo.SetTitle(arg.Title)
+	}
+	if arg.UpdateInterval > 0 {
+		subInfo.UpdateInterval = arg.UpdateInterval
+	}
+	return model.ConfigResult{
+		Body:     result,
+		SubInfo:  subInfo,
+		Warnings: warnings,
+		ETag:     ETag(result),
+		Time:     time.Now(),
+	}, nil
 }
 
-func applyProxyGroups(config map[string]any, groups []model.ProxyGroup) map[string]any {
-	if len(groups) == 0 {
-		return config
+// Explain 生成配置但只返回每个策略组 include/exclude 的展开过程
+func (c *Convert) Explain(cxt context.Context, arg model.ConvertArg, configByte []byte) (model.Explain, error) {
+	mr, err := c.makeMap(cxt, arg, configByte)
+	if err != nil {
+		return model.Explain{}, fmt.Errorf("Explain: %w", err)
 	}
-	outbounds := utils.AnyGet[[]any](config, "outbounds")
-	route := utils.AnyGet[map[string]any](config, "route")
-	ruleSet := utils.AnyGet[[]any](route, "rule_set")
-	rules := utils.AnyGet[[]any](route, "rules")
-	groupRules := make([]any, 0, len(groups))
-
-	for _, group := range groups {
-		tag := strings.TrimSpace(group.Tag)
-		if tag == "" {
-			continue
-		}
-		groupType := strings.TrimSpace(group.Type)
-		if groupType == "" {
-			groupType = "urltest"
-		}
-
-		include := strings.TrimSpace(group.Include)
-		exclude := strings.TrimSpace(group.Exclude)
-		if include == "" && exclude == "" {
-			include = ".*"
-		}
+	return model.Explain{
+		Include:  arg.Include,
+		Exclude:  arg.Exclude,
+		Groups:   mr.report,
+		Dedup:    mr.dedup,
+		Warnings: mr.warnings,
+	}, nil
+}
 
-		newOutbound := map[string]any{
-			"type":      groupType,
-			"tag":       tag,
-			"outbounds": []any{},
-		}
-		if groupType == "urltest" {
-			newOutbound["url"] = "https://cp.cloudflare.com/generate_204"
-			newOutbound["interval"] = "10m"
-			newOutbound["tolerance"] = 50
-		}
+// mapResult 生成配置过程中的结果，warnings 为无法完整处理的内容
+type mapResult struct {
+	config   map[string]any
+	subInfo  model.SubInfo
+	nodeTag  []TagWithVisible
+	report   []model.GroupReport
+	dedup    []model.DedupReport
+	warnings []string
+}
 
-		outboundItems := make([]any, 0, 2)
-		if include != "" {
-			outboundItems = append(outboundItems, "include: "+include)
-		}
-		if exclude != "" {
-			outboundItems = append(outboundItems, "exclude: "+exclude)
-		}
-		newOutbound["outbounds"] = outboundItems
-		outbounds = append(outbounds, newOutbound)
-
-		srsURL := strings.TrimSpace(group.SrsURL)
-		if srsURL != "" {
-			ruleSetTag := tag + "-rule-set"
-			ruleSet = append(ruleSet, map[string]any{
-				"tag":    ruleSetTag,
-				"type":   "remote",
-				"format": "binary",
-				"url":    srsURL,
-			})
-			groupRules = append(groupRules, map[string]any{
-				"rule_set": ruleSetTag,
-				"outbound": tag,
-			})
-		}
+// template 读取本次转换使用的模板，并完成占位符替换和旧版本模板的升级
+func (c *Convert) template(cxt context.Context, arg model.ConvertArg, configByte []byte) ([]byte, error) {
+	if arg.Config == nil && arg.ConfigUrl == "" {
+		arg.Config = configByte
 	}
-
-	if len(groupRules) > 0 {
-		insertIndex := len(rules)
-		for i, rule := range rules {
-			if isDirectFallbackRule(rule) {
-				insertIndex = i
-				break
-			}
+	if arg.ConfigUrl != "" {
+		b, err := httputils.HttpGet(cxt, c.c, arg.ConfigUrl, 1000*1000*10)
+		if err != nil {
+			return nil, fmt.Errorf("template: %w: %w", ErrUpstream, err)
 		}
-
-		rules = slices.Insert(rules, insertIndex, groupRules...)
+		arg.Config = b
 	}
-
-	utils.AnySet(&config, outbounds, "outbounds")
-	utils.AnySet(&route, ruleSet, "rule_set")
-	utils.AnySet(&route, rules, "rules")
-	utils.AnySet(&config, route, "route")
-	return config
+	tpl, err := renderTemplate(arg.Config, arg)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	tpl, changes, err := migrateTemplate(tpl, arg.Ver)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	for _, v := range changes {
+		c.l.DebugContext(cxt, v)
+	}
+	return tpl, nil
 }
 
-func isDirectFallbackRule(rule any) bool {
-	ruleMap, ok := rule.(map[string]any)
-	if !ok {
-		return false
+func (c *Convert) makeMap(cxt context.Context, arg model.ConvertArg, configByte []byte) (mapResult, error) {
+	tpl, err := c.template(cxt, arg, configByte)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
 	}
-
-	if utils.AnyGet[string](ruleMap, "outbound") != "direct" {
-		return false
+	rn, err := newRenamer(arg.Rename, arg.Emoji)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
 	}
-
-	if ipIsPrivate, ok := ruleMap["ip_is_private"].(bool); ok && ipIsPrivate {
-		return true
+	// 支持 jsonc
+	cr, err := convert2sing(cxt, c.c, jsonc.ToJSON(tpl), subscriptions(arg.Sub, arg.Subs), arg.Include, arg.Exclude, arg.AddTag, c.l, !arg.DisableUrlTest, arg.OutFields, arg.Ver, rn, arg.Dedup, arg.ClashRules || arg.ClashGroups || arg.ClashDNS, arg.ClashGroups)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
 	}
-
-	return utils.AnyGet[string](ruleMap, "rule_set") == "geoip-cn"
+	m, nodeTag, dedup := cr.config, cr.nodeTag, cr.dedup
+	for _, v := range dedup {
+		c.l.DebugContext(cxt, "dedup", "kept", v.Kept, "removed", v.Removed)
+	}
+	warnings := cr.warnings
+	m, w, err := applyProxyGroups(m, renameProxyGroups(arg.ProxyGroups, cr.rename), nodeTag)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
+	}
+	warnings = append(warnings, w...)
+	if arg.ClashRules {
+		var w []string
+		m, w = applyClashRules(m, cr.profile, cr.alias, arg.RuleSetBase)
+		warnings = append(warnings, w...)
+	}
+	if arg.ClashDNS {
+		var w []string
+		m, w = applyClashDNS(m, cr.profile, cr.alias)
+		warnings = append(warnings, w...)
+	}
+	m = applyInboundSettings(m, arg.EnableTun, arg.ProxyType, arg.ProxyPort)
+	warnings = append(warnings, downgradeTemplate(m, arg.Ver)...)
+	if n := lo.SumBy(dedup, func(item model.DedupReport) int { return len(item.Removed) }); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("dedup: removed %d duplicate nodes", n))
+	}
+	m, report, err := configUrlTestParser(m, nodeTag)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
+	}
+	m, regionReport, err := applyRegionGroups(m, nodeTag, arg.AutoRegionGroups, !arg.DisableUrlTest)
+	if err != nil {
+		return mapResult{}, fmt.Errorf("makeMap: %w", err)
+	}
+	report = append(report, regionReport...)
+	if arg.OverlayUrl != "" {
+		b, err := httputils.HttpGet(cxt, c.c, arg.OverlayUrl, 1000*1000*10)
+		if err != nil {
+			return mapResult{}, fmt.Errorf("makeMap: %w: %w", ErrUpstream, err)
+		}
+		arg.Overlay = b
+	}
+	if len(arg.Overlay) != 0 {
+		m, err = applyOverlay(m, arg.Overlay)
+		if err != nil {
+			return mapResult{}, fmt.Errorf("makeMap: %w", err)
+		}
+	}
+	for _, v := range warnings {
+		c.l.DebugContext(cxt, v)
+	}
+	return mapResult{
+		config:   m,
+		subInfo:  cr.subInfo,
+		nodeTag:  nodeTag,
+		report:   report,
+		dedup:    dedup,
+		warnings: warnings,
+	}, nil
 }
 
 func applyInboundSettings(config map[string]any, enableTun bool, proxyType string, proxyPort int) map[string]any {
@@ -241,7 +275,7 @@ func filter(reg string, tags []string, need bool) ([]string, error) {
 	}
 	r, err := regexp.Compile(reg)
 	if err != nil {
-		return nil, fmt.Errorf("filter: %w", err)
+		return nil, fmt.Errorf("filter: %w: %w", ErrFilter, err)
 	}
 	tag := lo.Filter(tags, func(item string, index int) bool {
 		has := r.MatchString(item)
@@ -250,31 +284,43 @@ func filter(reg string, tags []string, need bool) ([]string, error) {
 	return tag, nil
 }
 
-func configUrlTestParser(config map[string]any, tags []TagWithVisible) (map[string]any, error) {
+func configUrlTestParser(config map[string]any, tags []TagWithVisible) (map[string]any, []model.GroupReport, error) {
 	outL, ok := config["outbounds"].([]any)
 	if !ok {
-		return nil, fmt.Errorf("configUrlTestParser: outbounds is not []any or missing")
+		return nil, nil, fmt.Errorf("configUrlTestParser: outbounds is not []any or missing")
 	}
 
 	newOut := make([]any, 0, len(outL))
+	report := []model.GroupReport{}
 
 	for _, value := range outL {
 		outList := utils.AnyGet[[]any](value, "outbounds")
+		tag := utils.AnyGet[string](value, "tag")
+		t := utils.AnyGet[string](value, "type")
+		isGroup := t == "selector" || t == "urltest"
 
 		if len(outList) == 0 {
+			if isGroup {
+				report = append(report, model.GroupReport{
+					Tag:       tag,
+					Type:      t,
+					Outbounds: []string{},
+					Empty:     true,
+				})
+			}
 			newOut = append(newOut, value)
 			continue
 		}
 
-		tag := utils.AnyGet[string](value, "tag")
-
 		outListS := lo.FilterMap(outList, func(item any, index int) (string, bool) {
 			s, ok := item.(string)
 			return s, ok
 		})
 		var tagStr []string
+		detour := false
 
 		if tag != "" && utils.AnyGet[string](value, "detour") != "" {
+			detour = true
 			tagStr = lo.FilterMap(tags, func(item TagWithVisible, index int) (string, bool) {
 				return item.Tag, len(item.Visible) != 0 && slices.Contains(item.Visible, tag)
 			})
@@ -290,7 +336,10 @@ func configUrlTestParser(config map[string]any, tags []TagWithVisible) (map[stri
 
 		tl, err := urlTestParser(outListS, tagStr)
 		if err != nil {
-			return nil, fmt.Errorf("configUrlTestParser: %w", err)
+			return nil, nil, fmt.Errorf("configUrlTestParser: %w", err)
+		}
+		if isGroup {
+			report = append(report, groupReport(tag, t, outListS, tagStr, tl, detour))
 		}
 		if tl == nil {
 			newOut = append(newOut, value)
@@ -300,10 +349,34 @@ func configUrlTestParser(config map[string]any, tags []TagWithVisible) (map[stri
 		newOut = append(newOut, value)
 	}
 	utils.AnySet(&config, newOut, "outbounds")
-	return config, nil
+	return config, report, nil
 }
 
-func urlTestParser(outbounds, tags []string) ([]string, error) {
+func groupReport(tag, t string, outbounds, tags, result []string, detour bool) model.GroupReport {
+	include, exclude, extTag := parseDirectives(outbounds)
+	r := model.GroupReport{
+		Tag:       tag,
+		Type:      t,
+		Include:   include,
+		Exclude:   exclude,
+		Outbounds: outbounds,
+	}
+	r.Directives = lo.Filter(outbounds, func(item string, index int) bool {
+		return strings.HasPrefix(item, "include: ") || strings.HasPrefix(item, "exclude: ")
+	})
+	if result != nil {
+		r.Outbounds = result
+		r.Matched = lo.Without(result, extTag...)
+		r.Excluded = lo.Without(tags, r.Matched...)
+	}
+	if detour {
+		r.DetourTags = tags
+	}
+	r.Empty = len(r.Outbounds) == 0
+	return r
+}
+
+func parseDirectives(outbounds []string) (string, string, []string) {
 	var include, exclude string
 	extTag := []string{}
 
@@ -316,6 +389,11 @@ func urlTestParser(outbounds, tags []string) ([]string, error) {
 			extTag = append(extTag, s)
 		}
 	}
+	return include, exclude, extTag
+}
+
+func urlTestParser(outbounds, tags []string) ([]string, error) {
+	include, exclude, extTag := parseDirectives(outbounds)
 
 	if include == "" && exclude == "" {
 		return nil, nil
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+type ConfigCache = utils.Cache[model.ConfigResult]
+
+// RuleSetCache /ruleset 编译结果的缓存，与配置分开，规则集不会挤掉配置
+type RuleSetCache struct {
+	*utils.Cache[model.ConfigResult]
+}
+
+// ArgKey 参数的规范哈希，相同参数、版本和输出格式得到相同的 key
+func ArgKey(arg model.ConvertArg, userAgent string) string {
+	b, _ := json.Marshal(arg)
+	h := sha256.New()
+	h.Write(b)
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(int(arg.Ver))))
+	h.Write([]byte{0})
+	h.Write([]byte(arg.RuleSetBase))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatBool(utils.IsBrowser(userAgent))))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RuleSetKey 编译规则集的缓存 key
+func RuleSetKey(u, behavior, format string) string {
+	h := sha256.New()
+	h.Write([]byte("ruleset"))
+	for _, v := range []string{u, behavior, format} {
+		h.Write([]byte{0})
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func ETag(b []byte) string {
+	h := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
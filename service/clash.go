@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clashProfile 订阅中 clash 配置除节点以外的部分，httputils.GetAny 只保留了节点
+type clashProfile struct {
+	ProxyGroups   []clashProxyGroup            `yaml:"proxy-groups"`
+	Rules         []string                     `yaml:"rules"`
+	RuleProviders map[string]clashRuleProvider `yaml:"rule-providers"`
+	DNS           *clashDNS                    `yaml:"dns"`
+}
+
+type clashProxyGroup struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Proxies   []string `yaml:"proxies,omitempty"`
+	Use       []string `yaml:"use,omitempty"`
+	URL       string   `yaml:"url,omitempty"`
+	Interval  int      `yaml:"interval,omitempty"`
+	Tolerance int      `yaml:"tolerance,omitempty"`
+	// IncludeAll 和 IncludeAllProxies 时使用全部节点，再按 Filter 和 ExcludeFilter 过滤
+	IncludeAll        bool   `yaml:"include-all,omitempty"`
+	IncludeAllProxies bool   `yaml:"include-all-proxies,omitempty"`
+	Filter            string `yaml:"filter,omitempty"`
+	ExcludeFilter     string `yaml:"exclude-filter,omitempty"`
+}
+
+type clashRuleProvider struct {
+	Type     string `yaml:"type"`
+	Behavior string `yaml:"behavior,omitempty"`
+	Format   string `yaml:"format,omitempty"`
+	URL      string `yaml:"url,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Interval int    `yaml:"interval,omitempty"`
+	// Payload type 为 inline 时的规则
+	Payload []string `yaml:"payload,omitempty"`
+}
+
+type clashDNS struct {
+	Enable            bool                `yaml:"enable"`
+	IPv6              *bool               `yaml:"ipv6"`
+	EnhancedMode      string              `yaml:"enhanced-mode"`
+	FakeIPRange       string              `yaml:"fake-ip-range"`
+	FakeIPFilter      []string            `yaml:"fake-ip-filter"`
+	FakeIPFilterMode  string              `yaml:"fake-ip-filter-mode"`
+	DefaultNameserver []string            `yaml:"default-nameserver"`
+	Nameserver        []string            `yaml:"nameserver"`
+	Fallback          []string            `yaml:"fallback"`
+	FallbackFilter    clashFallbackFilter `yaml:"fallback-filter"`
+	NameserverPolicy  clashPolicy         `yaml:"nameserver-policy"`
+}
+
+type clashFallbackFilter struct {
+	GeoIP   bool     `yaml:"geoip"`
+	IPCIDR  []string `yaml:"ipcidr"`
+	Domain  []string `yaml:"domain"`
+	Geosite []string `yaml:"geosite"`
+}
+
+// clashPolicy nameserver-policy 按顺序匹配，值可以是单个服务器或列表
+type clashPolicy []clashPolicyEntry
+
+type clashPolicyEntry struct {
+	Domain  string
+	Servers []string
+}
+
+func (c *clashPolicy) UnmarshalYAML(value *yaml.Node) error {
+	// 格式错误时忽略，不影响配置中的其他部分
+	if value.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		e := clashPolicyEntry{Domain: value.Content[i].Value}
+		v := value.Content[i+1]
+		switch v.Kind {
+		case yaml.ScalarNode:
+			e.Servers = []string{v.Value}
+		case yaml.SequenceNode:
+			if err := v.Decode(&e.Servers); err != nil {
+				return fmt.Errorf("clashPolicy: %w", err)
+			}
+		default:
+			continue
+		}
+		*c = append(*c, e)
+	}
+	return nil
+}
+
+func (c *clashProfile) empty() bool {
+	return len(c.Rules) == 0 && len(c.ProxyGroups) == 0 && c.DNS == nil
+}
+
+// parseClashProfile 不是 clash 配置时返回 nil
+func parseClashProfile(b []byte) *clashProfile {
+	c := clashProfile{}
+	err := yaml.Unmarshal(b, &c)
+	if err != nil || c.empty() {
+		return nil
+	}
+	return &c
+}
+
+// bodyTransport 保存第一个成功响应的内容，用于读取节点以外的 clash 配置
+type bodyTransport struct {
+	base http.RoundTripper
+	l    sync.Mutex
+	body []byte
+}
+
+func (b *bodyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rep, err := b.base.RoundTrip(r)
+	if err != nil || rep.StatusCode != http.StatusOK {
+		return rep, err
+	}
+	body, err := io.ReadAll(io.LimitReader(rep.Body, 1000*1000*10))
+	rep.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	b.l.Lock()
+	if b.body == nil {
+		b.body = body
+	}
+	b.l.Unlock()
+	rep.Body = io.NopCloser(bytes.NewReader(body))
+	return rep, nil
+}
+
+func withBodyTransport(c *http.Client) (*http.Client, *bodyTransport) {
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &bodyTransport{base: base}
+	nc := *c
+	nc.Transport = t
+	return &nc, t
+}
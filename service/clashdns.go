@@ -0,0 +1,263 @@
+package service
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+// applyClashDNS 把订阅中 clash 的 dns 转换为 dns 服务器和规则，合并到模板的 dns 中。
+// 生成的服务器为 1.12 的 type/server 格式，旧版本由 downgradeTemplate 改为 address 格式。
+// 模板优先：只有模板没有 dns 服务器时才设置 final，模板已有 fakeip 和 strategy 时不修改，clash 的规则放在模板的规则之后。
+func applyClashDNS(config map[string]any, profile *clashProfile, alias map[string]string) (map[string]any, []string) {
+	if profile == nil || profile.DNS == nil || !profile.DNS.Enable || len(profile.DNS.Nameserver) == 0 {
+		return config, nil
+	}
+	d := profile.DNS
+	warnings := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	if dns == nil {
+		dns = map[string]any{}
+	}
+	route := utils.AnyGet[map[string]any](config, "route")
+	if route == nil {
+		route = map[string]any{}
+	}
+
+	servers := utils.AnyGet[[]any](dns, "servers")
+	// 模板中有服务器时第一个服务器就是默认服务器，不能被订阅的 nameserver 替换
+	templateServers := len(servers) != 0
+	tags := map[string]struct{}{}
+	local := ""
+	hasFakeIP := utils.AnyGet[map[string]any](dns, "fakeip") != nil
+	for _, v := range servers {
+		tag := utils.AnyGet[string](v, "tag")
+		tags[tag] = struct{}{}
+		t, address := utils.AnyGet[string](v, "type"), utils.AnyGet[string](v, "address")
+		if local == "" && (t == "local" || address == "local") {
+			local = tag
+		}
+		if t == "fakeip" || address == "fakeip" {
+			hasFakeIP = true
+		}
+	}
+	outbounds := map[string]struct{}{}
+	for _, v := range utils.AnyGet[[]any](config, "outbounds") {
+		outbounds[outboundTag(v)] = struct{}{}
+	}
+
+	newTag := func(prefix string) string {
+		tag := prefix
+		for i := 2; ; i++ {
+			if _, ok := tags[tag]; !ok {
+				break
+			}
+			tag = prefix + "-" + strconv.Itoa(i)
+		}
+		tags[tag] = struct{}{}
+		return tag
+	}
+
+	// 服务器为域名时需要 domain_resolver，优先使用 default-nameserver，其次是模板中的 local
+	resolver := func() string {
+		if local != "" {
+			return local
+		}
+		local = newTag("clash-local")
+		servers = append(servers, map[string]any{"type": "local", "tag": local})
+		return local
+	}
+	for _, v := range d.DefaultNameserver {
+		ns, _, err := clashDNSServer(v)
+		if err != nil {
+			continue
+		}
+		local = newTag("clash-default")
+		ns["tag"] = local
+		servers = append(servers, ns)
+		break
+	}
+
+	added := map[string]string{}
+	add := func(prefix string, list []string) string {
+		for _, v := range list {
+			if tag, ok := added[v]; ok {
+				return tag
+			}
+			ns, detour, err := clashDNSServer(v)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("clash dns: %v", err))
+				continue
+			}
+			switch {
+			case detour == "" || strings.EqualFold(detour, "DIRECT"):
+			case lo.HasKey(outbounds, detour):
+				ns["detour"] = detour
+			case alias[detour] != "":
+				ns["detour"] = alias[detour]
+			default:
+				warnings = append(warnings, fmt.Sprintf("clash dns: %s: unknown outbound %s", v, detour))
+			}
+			if host := utils.AnyGet[string](ns, "server"); host != "" {
+				if _, err := netip.ParseAddr(host); err != nil {
+					ns["domain_resolver"] = resolver()
+				}
+			}
+			tag := newTag(prefix)
+			ns["tag"] = tag
+			servers = append(servers, ns)
+			added[v] = tag
+			if len(list) > 1 {
+				warnings = append(warnings, fmt.Sprintf("clash dns: sing-box uses one server per rule, only %s of %s is used", v, prefix))
+			}
+			return tag
+		}
+		return ""
+	}
+
+	primary := add("clash-nameserver", d.Nameserver)
+	if primary == "" {
+		return config, warnings
+	}
+	if !templateServers && utils.AnyGet[string](dns, "final") == "" {
+		dns["final"] = primary
+	}
+
+	matchers := func(list []string) []clashMatcher {
+		m := []clashMatcher{}
+		for _, v := range list {
+			v = strings.TrimSpace(v)
+			switch {
+			case v == "":
+			case strings.HasPrefix(v, "geosite:"):
+				for _, code := range strings.Split(strings.TrimPrefix(v, "geosite:"), ",") {
+					m = append(m, clashMatcher{"rule_set", geoRuleSet(route, "geosite", code)})
+				}
+			case strings.Contains(v, ":"):
+				warnings = append(warnings, fmt.Sprintf("clash dns: %s is not supported", v))
+			default:
+				m = append(m, domainMatcher(v))
+			}
+		}
+		return m
+	}
+	rules := []any{}
+	addRules := func(m []clashMatcher, server string, extra map[string]any) {
+		for _, v := range headlessRules(m) {
+			rule := v.(map[string]any)
+			if server != "" {
+				rule["server"] = server
+			}
+			for k, v := range extra {
+				rule[k] = v
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	for _, v := range d.NameserverPolicy {
+		domains := strings.Split(v.Domain, ",")
+		if strings.HasPrefix(v.Domain, "geosite:") {
+			domains = []string{v.Domain}
+		}
+		// rcode:// 改为 predefined 动作
+		if len(v.Servers) != 0 {
+			if after, ok := strings.CutPrefix(v.Servers[0], "rcode://"); ok {
+				extra := map[string]any{"action": "predefined"}
+				if rcode := legacyRcode[after]; rcode != "" && rcode != "NOERROR" {
+					extra["rcode"] = rcode
+				}
+				addRules(matchers(domains), "", extra)
+				continue
+			}
+		}
+		server := add("clash-policy", v.Servers)
+		if server == "" {
+			continue
+		}
+		addRules(matchers(domains), server, nil)
+	}
+
+	if len(d.Fallback) != 0 {
+		server := add("clash-fallback", d.Fallback)
+		m := matchers(append(d.FallbackFilter.Domain, lo.Map(d.FallbackFilter.Geosite, func(item string, _ int) string {
+			return "geosite:" + item
+		})...))
+		if server != "" && len(m) == 0 {
+			warnings = append(warnings, fmt.Sprintf("clash dns: fallback %s has no domain filter and is not used by any rule", server))
+		}
+		if server != "" {
+			addRules(m, server, nil)
+		}
+		if d.FallbackFilter.GeoIP || len(d.FallbackFilter.IPCIDR) != 0 {
+			warnings = append(warnings, "clash dns: fallback-filter geoip and ipcidr are not supported")
+		}
+	}
+
+	if strings.EqualFold(d.EnhancedMode, "fake-ip") && !hasFakeIP {
+		r := netip.MustParsePrefix("198.18.0.0/15")
+		if p, err := netip.ParsePrefix(d.FakeIPRange); err == nil {
+			r = p.Masked()
+		}
+		fake := newTag("clash-fakeip")
+		servers = append(servers, map[string]any{
+			"type":        "fakeip",
+			"tag":         fake,
+			"inet4_range": r.String(),
+		})
+		queryType := map[string]any{"query_type": []any{"A", "AAAA"}}
+		if strings.EqualFold(d.FakeIPFilterMode, "whitelist") {
+			addRules(matchers(d.FakeIPFilter), fake, queryType)
+		} else {
+			addRules(matchers(d.FakeIPFilter), primary, nil)
+			queryType["server"] = fake
+			rules = append(rules, queryType)
+		}
+	}
+
+	if d.IPv6 != nil && !*d.IPv6 && utils.AnyGet[string](dns, "strategy") == "" {
+		dns["strategy"] = "ipv4_only"
+	}
+
+	utils.AnySet(&dns, servers, "servers")
+	if len(rules) != 0 {
+		utils.AnySet(&dns, append(utils.AnyGet[[]any](dns, "rules"), rules...), "rules")
+	}
+	utils.AnySet(&config, dns, "dns")
+	utils.AnySet(&config, route, "route")
+	return config, warnings
+}
+
+// clashDNSServer 转换 clash 的 dns 服务器地址，# 后为出站名称或参数，返回 1.12 格式的服务器和出站名称
+func clashDNSServer(address string) (map[string]any, string, error) {
+	address, fragment, _ := strings.Cut(strings.TrimSpace(address), "#")
+	detour := ""
+	h3 := false
+	for _, v := range strings.Split(fragment, "&") {
+		k, val, ok := strings.Cut(v, "=")
+		switch {
+		case !ok && k != "":
+			detour = k
+		case k == "h3":
+			h3 = val == "true"
+		}
+	}
+	switch {
+	case address == "system":
+		address = "local"
+	case address == "dhcp://system":
+		address = "dhcp://auto"
+	}
+	ns, err := typedDNSServer(address)
+	if err != nil {
+		return nil, "", fmt.Errorf("clashDNSServer: %w", err)
+	}
+	if h3 && ns["type"] == "https" {
+		ns["type"] = "h3"
+	}
+	return ns, detour, nil
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestClashDNSServer(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		detour  string
+		wantErr bool
+	}{
+		{address: "223.5.5.5", want: `{"type":"udp","server":"223.5.5.5"}`},
+		{address: "tls://1.1.1.1:853", want: `{"type":"tls","server":"1.1.1.1","server_port":853}`},
+		{address: "https://dns.google/dns-query#Proxy", want: `{"type":"https","server":"dns.google"}`, detour: "Proxy"},
+		{address: "https://dns.google/dns-query#h3=true", want: `{"type":"h3","server":"dns.google"}`},
+		{address: "https://1.1.1.1/dns-query#Proxy&h3=false", want: `{"type":"https","server":"1.1.1.1"}`, detour: "Proxy"},
+		{address: "system", want: `{"type":"local"}`},
+		{address: "dhcp://system", want: `{"type":"dhcp"}`},
+		{address: "dhcp://en0", want: `{"type":"dhcp","interface":"en0"}`},
+		{address: "ftp://1.1.1.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			ns, detour, err := clashDNSServer(tt.address)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want error", ns)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, ns, tt.want)
+			if detour != tt.detour {
+				t.Errorf("detour = %q, want %q", detour, tt.detour)
+			}
+		})
+	}
+}
+
+func TestApplyClashDNS(t *testing.T) {
+	const config = `{
+		"outbounds":[{"type":"selector","tag":"select"},{"type":"direct","tag":"direct"},{"type":"trojan","tag":"HK 1[a.com]"}],
+		"dns":{"servers":[{"type":"local","tag":"local"}],"rules":[{"clash_mode":"Direct","server":"local"}]}
+	}`
+	tests := []struct {
+		name     string
+		config   string
+		dns      string
+		want     string
+		warnings int
+	}{
+		{
+			name:   "disabled",
+			config: config,
+			dns:    "enable: false\nnameserver: [223.5.5.5]",
+			want:   config,
+		},
+		{
+			name:   "nameserver, policy and fallback",
+			config: config,
+			dns: `
+enable: true
+ipv6: false
+default-nameserver: [system]
+nameserver: ["https://dns.alidns.com/dns-query", "223.5.5.5"]
+fallback: ["tls://1.1.1.1#HK 1"]
+fallback-filter:
+  geoip: true
+  geosite: [gfw]
+nameserver-policy:
+  "+.corp.com,internal": "10.0.0.1"
+  "geosite:category-ads-all": "rcode://refused"
+`,
+			want: `{
+				"outbounds":[{"type":"selector","tag":"select"},{"type":"direct","tag":"direct"},{"type":"trojan","tag":"HK 1[a.com]"}],
+				"dns":{
+					"servers":[
+						{"type":"local","tag":"local"},
+						{"type":"local","tag":"clash-default"},
+						{"type":"https","tag":"clash-nameserver","server":"dns.alidns.com","domain_resolver":"clash-default"},
+						{"type":"udp","tag":"clash-policy","server":"10.0.0.1"},
+						{"type":"tls","tag":"clash-fallback","server":"1.1.1.1","detour":"HK 1[a.com]"}
+					],
+					"strategy":"ipv4_only",
+					"rules":[
+						{"clash_mode":"Direct","server":"local"},
+						{"domain_suffix":["corp.com"],"server":"clash-policy"},
+						{"domain":["internal"],"server":"clash-policy"},
+						{"rule_set":["geosite-category-ads-all"],"action":"predefined","rcode":"REFUSED"},
+						{"rule_set":["geosite-gfw"],"server":"clash-fallback"}
+					]
+				},
+				"route":{"rule_set":[
+					{"tag":"geosite-category-ads-all","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/category-ads-all.srs"},
+					{"tag":"geosite-gfw","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/gfw.srs"}
+				]}
+			}`,
+			// 多个 nameserver 只使用第一个，fallback-filter geoip 不支持
+			warnings: 2,
+		},
+		{
+			name:   "fake-ip and template wins",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"}],"dns":{"final":"local","strategy":"prefer_ipv6","servers":[{"tag":"local","address":"local"}]}}`,
+			dns: `
+enable: true
+ipv6: false
+enhanced-mode: fake-ip
+fake-ip-range: 198.18.0.1/16
+fake-ip-filter: ["+.lan", "geosite:private"]
+nameserver: ["tls://dns.google#Unknown"]
+`,
+			want: `{"outbounds":[{"type":"direct","tag":"direct"}],
+				"dns":{"final":"local","strategy":"prefer_ipv6",
+					"servers":[
+						{"tag":"local","address":"local"},
+						{"type":"tls","tag":"clash-nameserver","server":"dns.google","domain_resolver":"local"},
+						{"type":"fakeip","tag":"clash-fakeip","inet4_range":"198.18.0.0/16"}
+					],
+					"rules":[
+						{"domain_suffix":["lan"],"server":"clash-nameserver"},
+						{"rule_set":["geosite-private"],"server":"clash-nameserver"},
+						{"query_type":["A","AAAA"],"server":"clash-fakeip"}
+					]},
+				"route":{"rule_set":[
+					{"tag":"geosite-private","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/private.srs"}
+				]}
+			}`,
+			warnings: 1,
+		},
+		{
+			name:   "no template servers",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"}]}`,
+			dns:    "enable: true\nnameserver: [223.5.5.5]",
+			want: `{"outbounds":[{"type":"direct","tag":"direct"}],
+				"dns":{"servers":[{"type":"udp","tag":"clash-nameserver","server":"223.5.5.5"}],"final":"clash-nameserver"},
+				"route":{}}`,
+		},
+	}
+	alias := map[string]string{"HK 1": "HK 1[a.com]"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &clashProfile{}
+			if err := yaml.Unmarshal([]byte(tt.dns), &p.DNS); err != nil {
+				t.Fatal(err)
+			}
+			m, warnings := applyClashDNS(jsonMap(t, tt.config), p, alias)
+			jsonEqual(t, m, tt.want)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
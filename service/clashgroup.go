@@ -0,0 +1,256 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+// clashGroup 转换中的 clash 策略组
+type clashGroup struct {
+	g       clashProxyGroup
+	out     map[string]any
+	members []string
+	// directives include-all 时交给 configUrlTestParser 的过滤条件
+	directives []string
+}
+
+// clashGroupOutbounds 把 clash 配置中的 proxy-groups 转换为同名的 selector 和 urltest。
+// fallback 和 load-balance 在 sing-box 中没有对应的类型，转换为 urltest；
+// relay 转换为带 detour 的 selector，由 urlTestDetourSet 生成链式节点，超过两跳时额外返回隐藏的中间节点。
+// 与 reserved 中的 tag 同名的策略组不转换，返回没有被其他策略组引用的策略组，用于加入主 selector。
+func clashGroupOutbounds(profile *clashProfile, alias map[string]string, s []singbox.SingBoxOut, reserved []string) ([]map[string]any, []singbox.SingBoxOut, []string, []string) {
+	if profile == nil || len(profile.ProxyGroups) == 0 {
+		return nil, nil, nil, nil
+	}
+	warnings := []string{}
+	names := map[string]struct{}{}
+	nodes := map[string]singbox.SingBoxOut{}
+	for _, v := range s {
+		nodes[v.Tag] = v
+	}
+	list := []*clashGroup{}
+	for _, v := range profile.ProxyGroups {
+		if v.Name == "" {
+			continue
+		}
+		_, isNode := nodes[v.Name]
+		if slices.Contains(reserved, v.Name) || isNode {
+			warnings = append(warnings, fmt.Sprintf("clash groups: %s conflicts with an existing outbound, skipped", v.Name))
+			continue
+		}
+		if _, ok := names[v.Name]; ok {
+			continue
+		}
+		names[v.Name] = struct{}{}
+		list = append(list, &clashGroup{g: v})
+	}
+
+	missing := []string{}
+	member := func(name string) (string, bool) {
+		switch strings.ToUpper(name) {
+		case "DIRECT":
+			return "direct", true
+		case "REJECT", "REJECT-DROP", "PASS", "COMPATIBLE":
+			missing = append(missing, name)
+			return "", false
+		}
+		if _, ok := names[name]; ok {
+			return name, true
+		}
+		if tag, ok := alias[name]; ok {
+			return tag, true
+		}
+		missing = append(missing, name)
+		return "", false
+	}
+
+	for _, v := range list {
+		if len(v.g.Use) != 0 {
+			warnings = append(warnings, fmt.Sprintf("clash groups: %s: proxy-providers are not supported", v.g.Name))
+		}
+		if strings.ToLower(v.g.Type) == "relay" {
+			continue
+		}
+		for _, p := range v.g.Proxies {
+			if tag, ok := member(p); ok {
+				v.members = append(v.members, tag)
+			}
+		}
+		v.members = lo.Uniq(v.members)
+		if v.g.IncludeAll || v.g.IncludeAllProxies {
+			v.directives = append(v.directives, "include: "+lo.CoalesceOrEmpty(v.g.Filter, ".*"))
+			if v.g.ExcludeFilter != "" {
+				v.directives = append(v.directives, "exclude: "+v.g.ExcludeFilter)
+			}
+		}
+	}
+
+	byName := lo.SliceToMap(list, func(item *clashGroup) (string, *clashGroup) {
+		return item.g.Name, item
+	})
+	hidden := []singbox.SingBoxOut{}
+	for _, v := range list {
+		if strings.ToLower(v.g.Type) != "relay" {
+			continue
+		}
+		out, extra, err := clashRelay(v.g, byName, alias, nodes)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("clash groups: %v", err))
+			continue
+		}
+		v.out = out
+		hidden = append(hidden, extra...)
+	}
+
+	// 成员都无法转换的策略组去掉，并从引用它的策略组中移除，直到没有变化
+	removed := map[string]struct{}{}
+	removedList := []string{}
+	for changed := true; changed; {
+		changed = false
+		for _, v := range list {
+			if _, ok := removed[v.g.Name]; ok {
+				continue
+			}
+			v.members = lo.Filter(v.members, func(item string, _ int) bool {
+				_, ok := removed[item]
+				return !ok
+			})
+			if strings.ToLower(v.g.Type) == "relay" && v.out != nil {
+				continue
+			}
+			if len(v.members) == 0 && len(v.directives) == 0 {
+				removed[v.g.Name] = struct{}{}
+				removedList = append(removedList, v.g.Name)
+				changed = true
+			}
+		}
+	}
+	if len(removedList) != 0 {
+		warnings = append(warnings, fmt.Sprintf("clash groups: empty groups skipped: %s", strings.Join(removedList, ", ")))
+	}
+	if len(missing) != 0 {
+		warnings = append(warnings, fmt.Sprintf("clash groups: unknown members skipped: %s", strings.Join(lo.Uniq(missing), ", ")))
+	}
+
+	outs := []map[string]any{}
+	referenced := map[string]struct{}{}
+	for _, v := range list {
+		if _, ok := removed[v.g.Name]; ok {
+			continue
+		}
+		for _, m := range v.members {
+			referenced[m] = struct{}{}
+		}
+		if v.out != nil {
+			outs = append(outs, v.out)
+			continue
+		}
+		out := map[string]any{
+			"tag":       v.g.Name,
+			"outbounds": lo.ToAnySlice(append(v.directives, v.members...)),
+		}
+		switch t := strings.ToLower(v.g.Type); t {
+		case "select":
+			out["type"] = "selector"
+		case "url-test", "fallback", "load-balance":
+			out["type"] = "urltest"
+			if v.g.URL != "" {
+				out["url"] = v.g.URL
+			}
+			if v.g.Interval > 0 {
+				out["interval"] = fmt.Sprintf("%ds", v.g.Interval)
+			}
+			if v.g.Tolerance > 0 {
+				out["tolerance"] = v.g.Tolerance
+			}
+			if t != "url-test" {
+				warnings = append(warnings, fmt.Sprintf("clash groups: %s: %s is converted to urltest, the lowest latency outbound is used", v.g.Name, t))
+			}
+		default:
+			warnings = append(warnings, fmt.Sprintf("clash groups: %s: unsupported type %s, converted to selector", v.g.Name, v.g.Type))
+			out["type"] = "selector"
+		}
+		outs = append(outs, out)
+	}
+	top := lo.FilterMap(outs, func(item map[string]any, _ int) (string, bool) {
+		tag := utils.AnyGet[string](item, "tag")
+		_, ok := referenced[tag]
+		return tag, !ok
+	})
+	return outs, hidden, top, warnings
+}
+
+// clashRelay relay 的第一个成员作为入口，可以是节点或策略组，之后的成员必须是节点。
+// 入口通过 include 过滤 urlTestDetourSet 生成的 "入口 - 出口 [relay]" 节点。
+func clashRelay(g clashProxyGroup, groups map[string]*clashGroup, alias map[string]string, nodes map[string]singbox.SingBoxOut) (map[string]any, []singbox.SingBoxOut, error) {
+	if len(g.Proxies) < 2 {
+		return nil, nil, fmt.Errorf("%s: relay needs at least two proxies", g.Name)
+	}
+	entry := relayEntry(g.Proxies[0], groups, alias, map[string]struct{}{})
+	if len(entry) == 0 {
+		return nil, nil, fmt.Errorf("%s: relay entry %s has no nodes", g.Name, g.Proxies[0])
+	}
+
+	exits := make([]singbox.SingBoxOut, 0, len(g.Proxies)-1)
+	for _, v := range g.Proxies[1:] {
+		n, ok := nodes[alias[v]]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: relay hop %s must be a node", g.Name, v)
+		}
+		exits = append(exits, n)
+	}
+	detour := exits[0].Tag
+	hidden := []singbox.SingBoxOut{}
+	if len(exits) > 1 {
+		// 中间的节点复制一份并修改 detour，不影响原来的节点。
+		// 复制的节点以经过的路径和 relay 名称命名，如 "出口1 - 出口2 [relay]"，不同 relay 经过相同的节点时 tag 不会重复，
+		// 生成的链式节点为 "入口 - 出口1 - 出口2 [relay]"
+		prev := ""
+		for i, v := range exits {
+			v.Tag = strings.Join(lo.Map(exits[:i+1], func(item singbox.SingBoxOut, _ int) string {
+				return item.Tag
+			}), " - ") + " [" + g.Name + "]"
+			v.Detour = prev
+			v.Ignored = true
+			v.Visible = nil
+			prev = v.Tag
+			hidden = append(hidden, v)
+		}
+		detour = prev
+	}
+
+	entry = lo.Map(lo.Uniq(entry), func(item string, _ int) string {
+		return regexp.QuoteMeta(item)
+	})
+	return map[string]any{
+		"type":      "selector",
+		"tag":       g.Name,
+		"outbounds": []any{"include: ^(?:" + strings.Join(entry, "|") + ") - "},
+		"detour":    detour,
+	}, hidden, nil
+}
+
+func relayEntry(name string, groups map[string]*clashGroup, alias map[string]string, visited map[string]struct{}) []string {
+	if tag, ok := alias[name]; ok {
+		return []string{tag}
+	}
+	g, ok := groups[name]
+	if !ok {
+		return nil
+	}
+	if _, ok := visited[name]; ok {
+		return nil
+	}
+	visited[name] = struct{}{}
+	list := []string{}
+	for _, v := range g.g.Proxies {
+		list = append(list, relayEntry(v, groups, alias, visited)...)
+	}
+	return list
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+func TestClashGroupOutbounds(t *testing.T) {
+	s := []singbox.SingBoxOut{{Type: "trojan", Tag: "HK 1"}, {Type: "trojan", Tag: "US 1"}, {Type: "trojan", Tag: "JP 1"}}
+	alias := map[string]string{"HK 1": "HK 1", "US 1": "US 1", "JP 1": "JP 1"}
+	profile := &clashProfile{ProxyGroups: []clashProxyGroup{
+		{Name: "Proxy", Type: "select", Proxies: []string{"Auto", "Fallback", "DIRECT", "REJECT"}},
+		{Name: "Auto", Type: "url-test", Proxies: []string{"HK 1", "US 1"}, URL: "https://a.com/204", Interval: 300, Tolerance: 20},
+		{Name: "Fallback", Type: "fallback", IncludeAll: true, Filter: "HK|US", ExcludeFilter: "US"},
+		{Name: "Empty", Type: "select", Proxies: []string{"Missing"}},
+		{Name: "Uses empty", Type: "select", Proxies: []string{"Empty"}},
+		{Name: "select", Type: "select", Proxies: []string{"HK 1"}},
+		{Name: "Proxy", Type: "select", Proxies: []string{"JP 1"}},
+		{Name: "Chain", Type: "relay", Proxies: []string{"Auto", "JP 1"}},
+		{Name: "Bad chain", Type: "relay", Proxies: []string{"HK 1", "Auto"}},
+	}}
+
+	outs, hidden, top, warnings := clashGroupOutbounds(profile, alias, s, []string{"select"})
+	jsonEqual(t, outs, `[
+		{"type":"selector","tag":"Proxy","outbounds":["Auto","Fallback","direct"]},
+		{"type":"urltest","tag":"Auto","outbounds":["HK 1","US 1"],"url":"https://a.com/204","interval":"300s","tolerance":20},
+		{"type":"urltest","tag":"Fallback","outbounds":["include: HK|US","exclude: US"]},
+		{"type":"selector","tag":"Chain","outbounds":["include: ^(?:HK 1|US 1) - "],"detour":"JP 1"}
+	]`)
+	if len(hidden) != 0 {
+		t.Errorf("hidden = %+v", hidden)
+	}
+	if want := []string{"Proxy", "Chain"}; !reflect.DeepEqual(top, want) {
+		t.Errorf("top = %q, want %q", top, want)
+	}
+	// select 冲突、fallback 转换、Bad chain、空策略组和未知成员
+	if len(warnings) != 5 {
+		t.Errorf("warnings = %q, want 5", warnings)
+	}
+}
+
+func TestClashRelay(t *testing.T) {
+	nodes := map[string]singbox.SingBoxOut{
+		"A": {Type: "trojan", Tag: "A"},
+		"B": {Type: "trojan", Tag: "B"},
+		"C": {Type: "trojan", Tag: "C", Visible: []string{"x"}},
+	}
+	alias := map[string]string{"A": "A", "B": "B", "C": "C"}
+	out, hidden, err := clashRelay(clashProxyGroup{Name: "r", Type: "relay", Proxies: []string{"A", "B", "C"}}, nil, alias, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonEqual(t, out, `{"type":"selector","tag":"r","outbounds":["include: ^(?:A) - "],"detour":"B - C [r]"}`)
+	got := lo.Map(hidden, func(item singbox.SingBoxOut, _ int) [2]string { return [2]string{item.Tag, item.Detour} })
+	want := [][2]string{{"B [r]", ""}, {"B - C [r]", "B [r]"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hidden = %q, want %q", got, want)
+	}
+	for _, v := range hidden {
+		if !v.Ignored || v.Visible != nil {
+			t.Errorf("%s: ignored = %v, visible = %v", v.Tag, v.Ignored, v.Visible)
+		}
+	}
+	if nodes["B"].Detour != "" {
+		t.Error("original node changed")
+	}
+
+	_, _, err = clashRelay(clashProxyGroup{Name: "r", Proxies: []string{"A"}}, nil, alias, nodes)
+	if err == nil {
+		t.Error("want error for a single proxy")
+	}
+}
+
+// TestClashRelaySharedExits 经过相同节点的两个 relay 生成的中间节点不能重名
+func TestClashRelaySharedExits(t *testing.T) {
+	s := []singbox.SingBoxOut{{Type: "trojan", Tag: "A"}, {Type: "trojan", Tag: "B"}, {Type: "trojan", Tag: "C"}, {Type: "trojan", Tag: "D"}}
+	alias := map[string]string{"A": "A", "B": "B", "C": "C", "D": "D"}
+	profile := &clashProfile{ProxyGroups: []clashProxyGroup{
+		{Name: "r1", Type: "relay", Proxies: []string{"A", "B", "C"}},
+		{Name: "r2", Type: "relay", Proxies: []string{"D", "B", "C"}},
+	}}
+	outs, hidden, _, warnings := clashGroupOutbounds(profile, alias, s, nil)
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %q", warnings)
+	}
+	tags := lo.Map(hidden, func(item singbox.SingBoxOut, _ int) string { return item.Tag })
+	if want := []string{"B [r1]", "B - C [r1]", "B [r2]", "B - C [r2]"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("hidden = %q, want %q", tags, want)
+	}
+
+	all, _, _ := urlTestDetourSet(append(s, hidden...), outs, nil)
+	seen := map[string]struct{}{}
+	for _, v := range all {
+		if _, ok := seen[v.Tag]; ok {
+			t.Errorf("duplicate tag %q", v.Tag)
+		}
+		seen[v.Tag] = struct{}{}
+	}
+	visible := lo.FilterMap(all, func(item singbox.SingBoxOut, _ int) (string, bool) {
+		return item.Tag, len(item.Visible) == 1 && item.Visible[0] != "_hide"
+	})
+	for _, want := range []string{"A - B - C [r1]", "D - B - C [r2]"} {
+		if !lo.Contains(visible, want) {
+			t.Errorf("visible = %q, want %q", visible, want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// clashConfig 输出的 clash.meta 配置，tun 和 dns 由客户端设置
+type clashConfig struct {
+	MixedPort     int                          `yaml:"mixed-port,omitempty"`
+	Port          int                          `yaml:"port,omitempty"`
+	SocksPort     int                          `yaml:"socks-port,omitempty"`
+	AllowLan      bool                         `yaml:"allow-lan"`
+	Mode          string                       `yaml:"mode"`
+	LogLevel      string                       `yaml:"log-level"`
+	Proxies       []clashProxy                 `yaml:"proxies"`
+	ProxyGroups   []clashProxyGroup            `yaml:"proxy-groups"`
+	RuleProviders map[string]clashRuleProvider `yaml:"rule-providers,omitempty"`
+	Rules         []string                     `yaml:"rules"`
+}
+
+// clashRuleFields sing-box 规则字段对应的 clash 规则类型，group 不同的字段在 sing-box 中为与的关系
+var clashRuleFields = map[string]struct{ typ, group string }{
+	"domain":         {"DOMAIN", "destination"},
+	"domain_suffix":  {"DOMAIN-SUFFIX", "destination"},
+	"domain_keyword": {"DOMAIN-KEYWORD", "destination"},
+	"domain_regex":   {"DOMAIN-REGEX", "destination"},
+	"ip_cidr":        {"IP-CIDR", "destination"},
+	"ip_is_private":  {"GEOIP", "destination"},
+	"rule_set":       {"RULE-SET", "destination"},
+	"source_ip_cidr": {"SRC-IP-CIDR", "source"},
+	"port":           {"DST-PORT", "port"},
+	"source_port":    {"SRC-PORT", "source_port"},
+	"process_name":   {"PROCESS-NAME", "process"},
+	"process_path":   {"PROCESS-PATH", "process"},
+}
+
+// clashYAML 把生成的 sing-box 配置转换为 clash.meta 配置，节点、策略组和规则都来自同一份配置，
+// 所以过滤、重命名、proxyGroups 的规则集都会保留。返回无法转换的内容。
+func clashYAML(config map[string]any, arg model.ConvertArg) ([]byte, []string, error) {
+	warnings := []string{}
+	outs := []map[string]any{}
+	byTag := map[string]map[string]any{}
+	for _, v := range utils.AnyGet[[]any](config, "outbounds") {
+		m, err := outboundMap(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("clashYAML: %w", err)
+		}
+		outs = append(outs, m)
+		byTag[utils.AnyGet[string](m, "tag")] = m
+	}
+
+	c := clashConfig{
+		Mode:     "rule",
+		LogLevel: "info",
+		Proxies:  []clashProxy{},
+	}
+	switch arg.ProxyType {
+	case "http":
+		c.Port = arg.ProxyPort
+	case "socks5":
+		c.SocksPort = arg.ProxyPort
+	default:
+		c.MixedPort = arg.ProxyPort
+	}
+
+	// names clash 中可以引用的名称，值为 clash 中使用的名称
+	names := map[string]string{}
+	for _, v := range outs {
+		tag := utils.AnyGet[string](v, "tag")
+		switch t := utils.AnyGet[string](v, "type"); t {
+		case "direct":
+			names[tag] = "DIRECT"
+		case "block":
+			names[tag] = "REJECT"
+		case "selector", "urltest":
+			names[tag] = tag
+		case "dns", "shadowtls":
+		default:
+			p, err := toClashProxy(v, byTag)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("clash: %s: %v", tag, err))
+				continue
+			}
+			names[tag] = tag
+			c.Proxies = append(c.Proxies, p)
+		}
+	}
+
+	missing := []string{}
+	for _, v := range outs {
+		t := utils.AnyGet[string](v, "type")
+		if t != "selector" && t != "urltest" {
+			continue
+		}
+		g := clashProxyGroup{Name: utils.AnyGet[string](v, "tag"), Type: "select"}
+		def := utils.AnyGet[string](v, "default")
+		for _, m := range utils.AnyGet[[]any](v, "outbounds") {
+			s, _ := m.(string)
+			name, ok := names[s]
+			if !ok {
+				missing = append(missing, s)
+				continue
+			}
+			if s == def {
+				g.Proxies = append([]string{name}, g.Proxies...)
+				continue
+			}
+			g.Proxies = append(g.Proxies, name)
+		}
+		g.Proxies = lo.Uniq(g.Proxies)
+		if len(g.Proxies) == 0 {
+			warnings = append(warnings, fmt.Sprintf("clash: %s has no outbounds, DIRECT is used", g.Name))
+			g.Proxies = []string{"DIRECT"}
+		}
+		if t == "urltest" {
+			g.Type = "url-test"
+			g.URL = lo.CoalesceOrEmpty(utils.AnyGet[string](v, "url"), "https://www.gstatic.com/generate_204")
+			g.Interval = 180
+			if d, err := time.ParseDuration(utils.AnyGet[string](v, "interval")); err == nil && d >= time.Second {
+				g.Interval = int(d.Seconds())
+			}
+			g.Tolerance = anyInt(v["tolerance"])
+		}
+		c.ProxyGroups = append(c.ProxyGroups, g)
+	}
+	if len(missing) != 0 {
+		warnings = append(warnings, fmt.Sprintf("clash: unsupported outbounds removed from groups: %s", strings.Join(lo.Uniq(missing), ", ")))
+	}
+
+	route := utils.AnyGet[map[string]any](config, "route")
+	rules, providers, w, err := clashRules(route, names)
+	if err != nil {
+		return nil, nil, fmt.Errorf("clashYAML: %w", err)
+	}
+	warnings = append(warnings, w...)
+	final := "DIRECT"
+	if name, ok := names[utils.AnyGet[string](route, "final")]; ok {
+		final = name
+	} else if i := mainSelector(utils.AnyGet[[]any](config, "outbounds")); i != -1 {
+		final = names[outboundTag(utils.AnyGet[[]any](config, "outbounds")[i])]
+	}
+	c.Rules = append(rules, "MATCH,"+final)
+	c.RuleProviders = providers
+
+	bw := &bytes.Buffer{}
+	e := yaml.NewEncoder(bw)
+	e.SetIndent(2)
+	err = e.Encode(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("clashYAML: %w", err)
+	}
+	return bw.Bytes(), warnings, nil
+}
+
+// clashRules 转换 route.rules，规则中引用的规则集转换为 rule-providers。
+// sniff、hijack-dns 等动作和 clash_mode 规则在 clash 中不需要，直接忽略。
+// 无法转换的 inline 和 local 规则集返回错误，避免生成的配置缺少分流；clash 无法读取的远程规则集只给出警告。
+func clashRules(route map[string]any, names map[string]string) ([]string, map[string]clashRuleProvider, []string, error) {
+	warnings := []string{}
+	sets := map[string]map[string]any{}
+	for _, v := range utils.AnyGet[[]any](route, "rule_set") {
+		m, _ := v.(map[string]any)
+		sets[utils.AnyGet[string](m, "tag")] = m
+	}
+	providers := map[string]clashRuleProvider{}
+	failed := map[string]struct{}{}
+	var setErr error
+	provider := func(tag string) bool {
+		if _, ok := providers[tag]; ok {
+			return true
+		}
+		if _, ok := failed[tag]; ok {
+			return false
+		}
+		p, err := clashRuleProviderOf(sets[tag])
+		if err != nil {
+			failed[tag] = struct{}{}
+			if t := utils.AnyGet[string](sets[tag], "type"); t == "inline" || t == "local" {
+				setErr = cmp.Or(setErr, fmt.Errorf("clashRules: %w: rule set %s: %w", ErrRuleSet, tag, err))
+				return false
+			}
+			warnings = append(warnings, fmt.Sprintf("clash: rule set %s: %v", tag, err))
+			return false
+		}
+		providers[tag] = p
+		return true
+	}
+
+	rules := []string{}
+	skipped := []string{}
+	for _, v := range utils.AnyGet[[]any](route, "rules") {
+		rule, _ := v.(map[string]any)
+		target := ""
+		switch utils.AnyGet[string](rule, "action") {
+		case "", "route":
+			o := utils.AnyGet[string](rule, "outbound")
+			if o == "" || o == "dns-out" {
+				continue
+			}
+			name, ok := names[o]
+			if !ok {
+				skipped = append(skipped, fmt.Sprintf("outbound %s", o))
+				continue
+			}
+			target = name
+		case "reject":
+			target = "REJECT"
+			if utils.AnyGet[string](rule, "method") == "drop" {
+				target = "REJECT-DROP"
+			}
+		default:
+			continue
+		}
+		if _, ok := rule["clash_mode"]; ok {
+			continue
+		}
+		lines, ok := clashRuleLines(rule, provider)
+		if !ok {
+			b, _ := yaml.Marshal(rule)
+			skipped = append(skipped, strings.Join(strings.Fields(string(b)), " "))
+			continue
+		}
+		for _, l := range lines {
+			rules = append(rules, l+","+target)
+		}
+	}
+	if setErr != nil {
+		return nil, nil, nil, setErr
+	}
+	if len(skipped) != 0 {
+		warnings = append(warnings, fmt.Sprintf("clash: skipped %d unsupported rules, first: %s", len(skipped), skipped[0]))
+	}
+	return rules, providers, warnings, nil
+}
+
+// clashRuleLines 把一条 sing-box 规则转换为不带目标的 clash 规则，
+// 只支持同一组字段的规则，多组字段需要同时满足，无法用 clash 的单条规则表示
+func clashRuleLines(rule map[string]any, provider func(tag string) bool) ([]string, bool) {
+	lines := []string{}
+	group := ""
+	keys := lo.Keys(rule)
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := rule[k]
+		switch k {
+		case "outbound", "action", "method", "type":
+			continue
+		case "invert":
+			if v == true {
+				return nil, false
+			}
+			continue
+		}
+		f, ok := clashRuleFields[k]
+		if !ok || (group != "" && group != f.group) {
+			return nil, false
+		}
+		group = f.group
+		if k == "ip_is_private" {
+			if v == true {
+				lines = append(lines, "GEOIP,LAN")
+			}
+			continue
+		}
+		values := anyStrings(v)
+		if values == nil {
+			values = []any{v}
+		}
+		for _, item := range values {
+			s := fmt.Sprint(item)
+			switch k {
+			case "domain_suffix":
+				s = strings.TrimPrefix(s, ".")
+			case "rule_set":
+				// 无法转换的规则集已经有警告，只跳过这一个
+				if !provider(s) {
+					continue
+				}
+			}
+			lines = append(lines, f.typ+","+s)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, false
+	}
+	return lines, true
+}
+
+// clashRuleProviderOf inline 规则集中的域名、ip 等字段转换为 classical 的 inline rule-provider，
+// MetaCubeX 的 srs 规则集使用同一仓库中对应的 mrs 文件，local 和其他远程规则集 clash 无法读取
+func clashRuleProviderOf(set map[string]any) (clashRuleProvider, error) {
+	switch t := utils.AnyGet[string](set, "type"); t {
+	case "inline":
+		payload := []string{}
+		for _, v := range utils.AnyGet[[]any](set, "rules") {
+			m, _ := v.(map[string]any)
+			lines, ok := clashRuleLines(m, func(string) bool { return false })
+			if !ok {
+				b, _ := json.Marshal(m)
+				return clashRuleProvider{}, fmt.Errorf("clashRuleProviderOf: unsupported rule %s", b)
+			}
+			payload = append(payload, lines...)
+		}
+		if len(payload) == 0 {
+			return clashRuleProvider{}, fmt.Errorf("clashRuleProviderOf: empty rule set")
+		}
+		return clashRuleProvider{Type: "inline", Behavior: "classical", Payload: payload}, nil
+	case "remote":
+		u := utils.AnyGet[string](set, "url")
+		if strings.Contains(u, "/meta-rules-dat/sing/") && strings.HasSuffix(u, ".srs") {
+			behavior := "domain"
+			if strings.Contains(u, "/geoip/") {
+				behavior = "ipcidr"
+			}
+			return clashRuleProvider{
+				Type:     "http",
+				Behavior: behavior,
+				Format:   "mrs",
+				URL:      strings.TrimSuffix(strings.Replace(u, "/meta-rules-dat/sing/", "/meta-rules-dat/meta/", 1), ".srs") + ".mrs",
+				Interval: 86400,
+			}, nil
+		}
+		return clashRuleProvider{}, fmt.Errorf("clashRuleProviderOf: %s can not be used by clash", u)
+	case "local":
+		return clashRuleProvider{}, fmt.Errorf("clashRuleProviderOf: local file %s can not be read", utils.AnyGet[string](set, "path"))
+	default:
+		return clashRuleProvider{}, fmt.Errorf("clashRuleProviderOf: unsupported type %q", t)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"gopkg.in/yaml.v3"
+)
+
+func TestClashRules(t *testing.T) {
+	names := map[string]string{"direct": "DIRECT", "block": "REJECT", "select": "select", "HK": "HK"}
+	tests := []struct {
+		name      string
+		route     string
+		want      []string
+		providers []string
+		warnings  int
+		wantErr   bool
+	}{
+		{
+			name: "fields and targets",
+			route: `{"rules":[
+				{"action":"sniff"},
+				{"protocol":"dns","action":"hijack-dns"},
+				{"protocol":"dns","outbound":"dns-out"},
+				{"clash_mode":"direct","outbound":"direct"},
+				{"domain":["a.com"],"domain_suffix":[".b.com","c.com"],"outbound":"HK"},
+				{"ip_is_private":true,"ip_cidr":"10.0.0.0/8","outbound":"direct"},
+				{"port":[80,443],"action":"route","outbound":"select"},
+				{"process_name":["curl"],"action":"reject"},
+				{"domain_keyword":["ad"],"action":"reject","method":"drop"}
+			]}`,
+			want: []string{
+				"DOMAIN,a.com,HK", "DOMAIN-SUFFIX,b.com,HK", "DOMAIN-SUFFIX,c.com,HK",
+				"IP-CIDR,10.0.0.0/8,DIRECT", "GEOIP,LAN,DIRECT",
+				"DST-PORT,80,select", "DST-PORT,443,select",
+				"PROCESS-NAME,curl,REJECT",
+				"DOMAIN-KEYWORD,ad,REJECT-DROP",
+			},
+			providers: []string{},
+		},
+		{
+			name: "unsupported rules are skipped",
+			route: `{"rules":[
+				{"domain":["a.com"],"port":443,"outbound":"HK"},
+				{"domain":["a.com"],"invert":true,"outbound":"HK"},
+				{"network":"udp","outbound":"HK"},
+				{"domain":["a.com"],"outbound":"missing"},
+				{"domain":["b.com"],"outbound":"HK"}
+			]}`,
+			want:      []string{"DOMAIN,b.com,HK"},
+			providers: []string{},
+			warnings:  1,
+		},
+		{
+			name: "rule sets",
+			route: `{"rule_set":[
+					{"tag":"geosite-cn","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/cn.srs"},
+					{"tag":"geoip-cn","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geoip/cn.srs"},
+					{"tag":"inline","type":"inline","rules":[{"domain":["a.com"]},{"ip_cidr":["1.1.1.1/32"]}]},
+					{"tag":"other","type":"remote","url":"https://example.com/a.srs"}
+				],
+				"rules":[
+					{"rule_set":["geosite-cn","other"],"outbound":"direct"},
+					{"rule_set":"geoip-cn","outbound":"direct"},
+					{"rule_set":"inline","outbound":"HK"},
+					{"rule_set":"other","outbound":"HK"}
+				]}`,
+			want:      []string{"RULE-SET,geosite-cn,DIRECT", "RULE-SET,geoip-cn,DIRECT", "RULE-SET,inline,HK"},
+			providers: []string{"geoip-cn", "geosite-cn", "inline"},
+			// other 的警告只有一次，加上被跳过的规则
+			warnings: 2,
+		},
+		{
+			name: "unsupported inline rule set",
+			route: `{"rule_set":[{"tag":"inline","type":"inline","rules":[{"domain":["a.com"],"port":443}]}],
+				"rules":[{"rule_set":"inline","outbound":"HK"}]}`,
+			wantErr: true,
+		},
+		{
+			name: "local rule set",
+			route: `{"rule_set":[{"tag":"local","type":"local","format":"source","path":"a.json"}],
+				"rules":[{"rule_set":"local","outbound":"HK"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, providers, warnings, err := clashRules(jsonMap(t, tt.route), names)
+			if tt.wantErr {
+				if !errors.Is(err, ErrRuleSet) {
+					t.Fatalf("err = %v, want ErrRuleSet", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("rules = %q, want %q", rules, tt.want)
+			}
+			keys := []string{}
+			for k := range providers {
+				keys = append(keys, k)
+			}
+			if len(keys) != len(tt.providers) {
+				t.Errorf("providers = %v, want %v", keys, tt.providers)
+			}
+			for _, k := range tt.providers {
+				if _, ok := providers[k]; !ok {
+					t.Errorf("provider %s not found", k)
+				}
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestClashRuleProviderOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     string
+		want    clashRuleProvider
+		wantErr bool
+	}{
+		{
+			name: "geosite",
+			set:  `{"type":"remote","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/cn.srs"}`,
+			want: clashRuleProvider{Type: "http", Behavior: "domain", Format: "mrs", URL: "https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/meta/geo/geosite/cn.mrs", Interval: 86400},
+		},
+		{
+			name: "geoip",
+			set:  `{"type":"remote","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geoip/cn.srs"}`,
+			want: clashRuleProvider{Type: "http", Behavior: "ipcidr", Format: "mrs", URL: "https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/meta/geo/geoip/cn.mrs", Interval: 86400},
+		},
+		{
+			name: "inline",
+			set:  `{"type":"inline","rules":[{"domain_suffix":["a.com"],"domain":"b.com"},{"source_ip_cidr":["10.0.0.0/8"]}]}`,
+			want: clashRuleProvider{Type: "inline", Behavior: "classical", Payload: []string{"DOMAIN,b.com", "DOMAIN-SUFFIX,a.com", "SRC-IP-CIDR,10.0.0.0/8"}},
+		},
+		{
+			name:    "inline with nested rule set",
+			set:     `{"type":"inline","rules":[{"rule_set":["a"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "other remote",
+			set:     `{"type":"remote","url":"https://example.com/a.srs"}`,
+			wantErr: true,
+		},
+		{
+			name:    "local",
+			set:     `{"type":"local","path":"a.srs"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := clashRuleProviderOf(jsonMap(t, tt.set))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestClashYAML(t *testing.T) {
+	config := jsonMap(t, `{"outbounds":[
+		{"type":"selector","tag":"select","outbounds":["auto","HK 1","US 1","direct"],"default":"US 1"},
+		{"type":"urltest","tag":"auto","outbounds":["HK 1","US 1","dns-out"],"interval":"5m","tolerance":50},
+		{"type":"selector","tag":"empty","outbounds":["dns-out"]},
+		{"type":"shadowsocks","tag":"HK 1","server":"1.1.1.1","server_port":8388,"method":"aes-128-gcm","password":"p"},
+		{"type":"trojan","tag":"US 1","server":"a.com","server_port":443,"password":"p","tls":{"enabled":true,"server_name":"b.com"}},
+		{"type":"direct","tag":"direct"},
+		{"type":"dns","tag":"dns-out"}
+	],
+	"route":{"rules":[{"domain_suffix":["cn"],"outbound":"direct"}]}}`)
+
+	b, warnings, err := clashYAML(config, model.ConvertArg{ProxyType: "socks5", ProxyPort: 1080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c clashConfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.SocksPort != 1080 || c.MixedPort != 0 {
+		t.Errorf("socks-port = %d, mixed-port = %d", c.SocksPort, c.MixedPort)
+	}
+	if len(c.Proxies) != 2 || c.Proxies[0].Type != "ss" || c.Proxies[1].Type != "trojan" {
+		t.Errorf("proxies = %+v", c.Proxies)
+	}
+	wantGroups := []clashProxyGroup{
+		{Name: "select", Type: "select", Proxies: []string{"US 1", "auto", "HK 1", "DIRECT"}},
+		{Name: "auto", Type: "url-test", Proxies: []string{"HK 1", "US 1"}, URL: "https://www.gstatic.com/generate_204", Interval: 300, Tolerance: 50},
+		{Name: "empty", Type: "select", Proxies: []string{"DIRECT"}},
+	}
+	if !reflect.DeepEqual(c.ProxyGroups, wantGroups) {
+		t.Errorf("proxy-groups = %+v\nwant %+v", c.ProxyGroups, wantGroups)
+	}
+	// 没有 route.final 时使用主 selector
+	wantRules := []string{"DOMAIN-SUFFIX,cn,DIRECT", "MATCH,select"}
+	if !reflect.DeepEqual(c.Rules, wantRules) {
+		t.Errorf("rules = %q, want %q", c.Rules, wantRules)
+	}
+	// empty 没有可用的出站，dns-out 被移除
+	if len(warnings) != 2 {
+		t.Errorf("warnings = %q, want 2", warnings)
+	}
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+const (
+	geositeURL = "https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/%s.srs"
+	geoipURL   = "https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geoip/%s.srs"
+)
+
+// applyClashRules 把订阅中 clash 的 rules 和 rule-providers 转换后加入 route。
+// 模板优先：clash 规则放在模板和 proxyGroups 的规则之后，MATCH 只在模板没有 route.final 时生效，
+// 与模板中规则集 tag 相同的 rule-provider 改名为 clash-<name> 并给出警告，base 为本服务的地址。返回无法转换的内容。
+func applyClashRules(config map[string]any, profile *clashProfile, alias map[string]string, base string) (map[string]any, []string) {
+	if profile == nil || len(profile.Rules) == 0 {
+		return config, nil
+	}
+	warnings := []string{}
+	route := utils.AnyGet[map[string]any](config, "route")
+	if route == nil {
+		route = map[string]any{}
+	}
+	ruleSet := utils.AnyGet[[]any](route, "rule_set")
+	ruleSetTag := map[string]struct{}{}
+	for _, v := range ruleSet {
+		ruleSetTag[utils.AnyGet[string](v, "tag")] = struct{}{}
+	}
+
+	providers, order, w := clashProviders(profile, ruleSetTag, base)
+	warnings = append(warnings, w...)
+	for _, name := range order {
+		if v, ok := providers[name]; ok {
+			ruleSet = append(ruleSet, v.set)
+		}
+	}
+	utils.AnySet(&route, ruleSet, "rule_set")
+
+	t := newClashTargets(config, alias)
+	rules := []any{}
+	var last map[string]any
+	lastField, lastTarget := "", ""
+	skipped := []string{}
+	final := ""
+
+	for _, line := range profile.Rules {
+		f := strings.Split(line, ",")
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		typ := strings.ToUpper(f[0])
+		if typ == "MATCH" {
+			if len(f) >= 2 {
+				final = f[1]
+			}
+			continue
+		}
+		if len(f) < 3 {
+			skipped = append(skipped, line)
+			continue
+		}
+		value, target := f[1], f[2]
+
+		var m clashMatcher
+		switch typ {
+		case "GEOSITE":
+			m = clashMatcher{"rule_set", geoRuleSet(route, "geosite", value)}
+		case "GEOIP":
+			if strings.EqualFold(value, "lan") {
+				m = clashMatcher{"ip_is_private", true}
+				break
+			}
+			m = clashMatcher{"rule_set", geoRuleSet(route, "geoip", value)}
+		case "RULE-SET":
+			p, ok := providers[value]
+			if !ok {
+				skipped = append(skipped, line)
+				continue
+			}
+			m = clashMatcher{"rule_set", utils.AnyGet[string](p.set, "tag")}
+		default:
+			var ok bool
+			m, ok = clashRuleMatcher(typ, value)
+			if !ok {
+				skipped = append(skipped, line)
+				continue
+			}
+		}
+
+		// 相邻且目标和字段都相同的规则合并为一条
+		if _, isBool := m.value.(bool); !isBool && last != nil && lastField == m.field && lastTarget == target {
+			last[m.field] = append(last[m.field].([]any), m.value)
+			continue
+		}
+		rule := t.action(target)
+		if b, ok := m.value.(bool); ok {
+			rule[m.field] = b
+		} else {
+			rule[m.field] = []any{m.value}
+		}
+		rules = append(rules, rule)
+		last, lastField, lastTarget = rule, m.field, target
+	}
+
+	if final != "" && utils.AnyGet[string](route, "final") == "" {
+		if o := utils.AnyGet[string](t.action(final), "outbound"); o != "" {
+			route["final"] = o
+		}
+	}
+
+	if len(skipped) > 0 {
+		warnings = append(warnings, fmt.Sprintf("clash rules: skipped %d unsupported rules, first: %s", len(skipped), skipped[0]))
+	}
+	if len(t.unknown) > 0 {
+		warnings = append(warnings, fmt.Sprintf("clash rules: unknown targets %s routed to %s", strings.Join(lo.Uniq(t.unknown), ", "), t.fallback))
+	}
+
+	utils.AnySet(&route, append(utils.AnyGet[[]any](route, "rules"), rules...), "rules")
+	utils.AnySet(&config, route, "route")
+	return config, warnings
+}
+
+// geoRuleSet 返回 geosite 或 geoip 对应的规则集 tag，模板中没有时加入 MetaCubeX 的规则集
+func geoRuleSet(route map[string]any, kind, code string) string {
+	code = strings.ToLower(code)
+	tag := kind + "-" + code
+	ruleSet := utils.AnyGet[[]any](route, "rule_set")
+	for _, v := range ruleSet {
+		if utils.AnyGet[string](v, "tag") == tag {
+			return tag
+		}
+	}
+	u := fmt.Sprintf(geositeURL, code)
+	if kind == "geoip" {
+		u = fmt.Sprintf(geoipURL, code)
+	}
+	utils.AnySet(&route, append(ruleSet, map[string]any{
+		"tag":    tag,
+		"type":   "remote",
+		"format": "binary",
+		"url":    u,
+	}), "rule_set")
+	return tag
+}
+
+// clashTargets 把 clash 规则的目标对应到 sing-box 的出站或动作
+type clashTargets struct {
+	tags     map[string]struct{}
+	alias    map[string]string
+	fallback string
+	unknown  []string
+}
+
+func newClashTargets(config map[string]any, alias map[string]string) *clashTargets {
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	t := &clashTargets{
+		tags:     map[string]struct{}{},
+		alias:    alias,
+		fallback: "direct",
+	}
+	for _, v := range outbounds {
+		t.tags[outboundTag(v)] = struct{}{}
+	}
+	if i := mainSelector(outbounds); i != -1 {
+		t.fallback = outboundTag(outbounds[i])
+	}
+	return t
+}
+
+// action 找不到的目标使用主 selector
+func (t *clashTargets) action(target string) map[string]any {
+	switch strings.ToUpper(target) {
+	case "DIRECT":
+		return map[string]any{"outbound": "direct"}
+	case "REJECT", "REJECT-TINYDROP":
+		return map[string]any{"action": "reject"}
+	case "REJECT-DROP":
+		return map[string]any{"action": "reject", "method": "drop"}
+	}
+	if _, ok := t.tags[target]; ok {
+		return map[string]any{"outbound": target}
+	}
+	if tag, ok := t.alias[target]; ok {
+		return map[string]any{"outbound": tag}
+	}
+	t.unknown = append(t.unknown, target)
+	return map[string]any{"outbound": t.fallback}
+}
+
+type clashProvider struct {
+	set map[string]any
+}
+
+// clashProviders 把 RULE-SET 中用到的 rule-provider 转换为规则集：inline 直接转换，http 不在这里下载，
+// 使用远程规则集指向 srs 文件本身或者本服务的 /ruleset，由 sing-box 按 interval 更新。
+// 无法转换的规则集不影响其他规则，只返回警告。order 为规则中引用的顺序
+func clashProviders(profile *clashProfile, exist map[string]struct{}, base string) (map[string]clashProvider, []string, []string) {
+	used := []string{}
+	for _, v := range profile.Rules {
+		f := strings.Split(v, ",")
+		if len(f) >= 3 && strings.EqualFold(strings.TrimSpace(f[0]), "RULE-SET") {
+			used = append(used, strings.TrimSpace(f[1]))
+		}
+	}
+	used = lo.Uniq(used)
+
+	providers := map[string]clashProvider{}
+	warnings := []string{}
+	for _, name := range used {
+		p, ok := profile.RuleProviders[name]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("clash rules: rule-provider %s not found", name))
+			continue
+		}
+		tag := name
+		if _, ok := exist[name]; ok {
+			tag = "clash-" + name
+			warnings = append(warnings, fmt.Sprintf("clash rules: rule-provider %s conflicts with a template rule_set, renamed to %s", name, tag))
+		}
+		set, skipped, err := providerRuleSet(p, base)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("clash rules: rule-provider %s: %v", name, err))
+			continue
+		}
+		if skipped > 0 {
+			warnings = append(warnings, fmt.Sprintf("clash rules: rule-provider %s: skipped %d unsupported rules", name, skipped))
+		}
+		set["tag"] = tag
+		providers[name] = clashProvider{set: set}
+	}
+	return providers, used, warnings
+}
+
+// providerRuleSet 返回不含 tag 的规则集和 inline 时无法转换的条数
+func providerRuleSet(p clashRuleProvider, base string) (map[string]any, int, error) {
+	behavior := strings.ToLower(cmp.Or(p.Behavior, "classical"))
+	if behavior != "classical" && behavior != "domain" && behavior != "ipcidr" {
+		return nil, 0, fmt.Errorf("providerRuleSet: unsupported behavior %q", p.Behavior)
+	}
+	switch strings.ToLower(p.Type) {
+	case "inline":
+		rules, skipped := providerRules(p.Payload, behavior)
+		if len(rules) == 0 {
+			return nil, skipped, fmt.Errorf("providerRuleSet: no rules")
+		}
+		return map[string]any{"type": "inline", "rules": rules}, skipped, nil
+	case "http":
+		if strings.EqualFold(p.Format, "mrs") {
+			return nil, 0, fmt.Errorf("providerRuleSet: mrs format is not supported")
+		}
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, 0, fmt.Errorf("providerRuleSet: invalid url %q", p.URL)
+		}
+		set := map[string]any{"type": "remote", "format": "binary", "url": p.URL}
+		if !strings.HasSuffix(u.Path, ".srs") {
+			if base == "" {
+				return nil, 0, fmt.Errorf("providerRuleSet: %s is not a sing-box rule set", p.URL)
+			}
+			set["url"] = base + "/ruleset?" + url.Values{"url": {p.URL}, "behavior": {behavior}}.Encode()
+		}
+		if p.Interval > 0 {
+			set["update_interval"] = fmt.Sprintf("%ds", p.Interval)
+		}
+		return set, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("providerRuleSet: unsupported type %q", p.Type)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestApplyClashRules(t *testing.T) {
+	const config = `{
+		"outbounds":[{"type":"selector","tag":"select","outbounds":["HK 1[a.com]"]},{"type":"direct","tag":"direct"},{"type":"trojan","tag":"HK 1[a.com]"}],
+		"route":{"rule_set":[{"tag":"ads","type":"remote","url":"https://a.com/ads.srs"}],"rules":[{"protocol":"dns","action":"hijack-dns"}]}
+	}`
+	tests := []struct {
+		name     string
+		config   string
+		profile  *clashProfile
+		want     string
+		warnings int
+	}{
+		{
+			name:    "no rules",
+			config:  config,
+			profile: &clashProfile{},
+			want:    config,
+		},
+		{
+			name:   "rules and targets",
+			config: config,
+			profile: &clashProfile{Rules: []string{
+				"DOMAIN-SUFFIX,google.com,HK 1",
+				"DOMAIN-SUFFIX,youtube.com,HK 1",
+				"DOMAIN,a.com,DIRECT",
+				"GEOIP,LAN,DIRECT,no-resolve",
+				"GEOSITE,CN,DIRECT",
+				"GEOIP,cn,DIRECT",
+				"DST-PORT,22,REJECT",
+				"IP-CIDR,1.1.1.1/32,REJECT-DROP",
+				"DOMAIN,b.com,Unknown",
+				"NETWORK,udp,DIRECT",
+				"MATCH,select",
+			}},
+			want: `{
+				"outbounds":[{"type":"selector","tag":"select","outbounds":["HK 1[a.com]"]},{"type":"direct","tag":"direct"},{"type":"trojan","tag":"HK 1[a.com]"}],
+				"route":{
+					"rule_set":[
+						{"tag":"ads","type":"remote","url":"https://a.com/ads.srs"},
+						{"tag":"geosite-cn","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geosite/cn.srs"},
+						{"tag":"geoip-cn","type":"remote","format":"binary","url":"https://raw.githubusercontent.com/MetaCubeX/meta-rules-dat/sing/geo/geoip/cn.srs"}
+					],
+					"rules":[
+						{"protocol":"dns","action":"hijack-dns"},
+						{"domain_suffix":["google.com","youtube.com"],"outbound":"HK 1[a.com]"},
+						{"domain":["a.com"],"outbound":"direct"},
+						{"ip_is_private":true,"outbound":"direct"},
+						{"rule_set":["geosite-cn","geoip-cn"],"outbound":"direct"},
+						{"port":[22],"action":"reject"},
+						{"ip_cidr":["1.1.1.1/32"],"action":"reject","method":"drop"},
+						{"domain":["b.com"],"outbound":"select"}
+					],
+					"final":"select"
+				}
+			}`,
+			// 不支持的规则和未知的目标
+			warnings: 2,
+		},
+		{
+			name:   "template final wins and providers are renamed",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"}],"route":{"final":"direct","rule_set":[{"tag":"ads","type":"remote","url":"https://a.com/ads.srs"}]}}`,
+			profile: &clashProfile{
+				Rules: []string{"RULE-SET,ads,REJECT", "RULE-SET,cn,DIRECT", "RULE-SET,none,DIRECT", "MATCH,REJECT"},
+				RuleProviders: map[string]clashRuleProvider{
+					"ads": {Type: "inline", Behavior: "domain", Payload: []string{"+.ad.com"}},
+					"cn":  {Type: "inline", Behavior: "ipcidr", Payload: []string{"1.0.1.0/24"}},
+				},
+			},
+			want: `{"outbounds":[{"type":"direct","tag":"direct"}],"route":{"final":"direct",
+				"rule_set":[
+					{"tag":"ads","type":"remote","url":"https://a.com/ads.srs"},
+					{"tag":"clash-ads","type":"inline","rules":[{"domain_suffix":["ad.com"]}]},
+					{"tag":"cn","type":"inline","rules":[{"ip_cidr":["1.0.1.0/24"]}]}
+				],
+				"rules":[
+					{"rule_set":["clash-ads"],"action":"reject"},
+					{"rule_set":["cn"],"outbound":"direct"}
+				]}}`,
+			// none 不存在、ads 改名以及被跳过的 RULE-SET,none
+			warnings: 3,
+		},
+	}
+	alias := map[string]string{"HK 1": "HK 1[a.com]"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, warnings := applyClashRules(jsonMap(t, tt.config), tt.profile, alias, "https://c.com")
+			jsonEqual(t, m, tt.want)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
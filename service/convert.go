@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"maps"
 	"net/http"
+	"regexp/syntax"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -13,48 +15,96 @@ import (
 
 	"github.com/samber/lo"
 	"github.com/tidwall/gjson"
+	cmodel "github.com/xmdhs/clash2sfa/model"
 	"github.com/xmdhs/clash2sfa/utils"
 	"github.com/xmdhs/clash2singbox/convert"
-	"github.com/xmdhs/clash2singbox/httputils"
 	"github.com/xmdhs/clash2singbox/model"
 	"github.com/xmdhs/clash2singbox/model/singbox"
 )
 
-func convert2sing(cxt context.Context, client *http.Client, config []byte,
-	sub string, include, exclude string, addTag bool, l *slog.Logger, urlTestOut bool, outFields bool, ver model.SingBoxVer) (map[string]any, []TagWithVisible, error) {
-	c, singList, tags, err := httputils.GetAny(cxt, client, sub, addTag)
-	if err != nil {
-		return nil, nil, fmt.Errorf("convert2sing: %w", err)
-	}
+// convertResult convert2sing 的结果
+type convertResult struct {
+	config  map[string]any
+	nodeTag []TagWithVisible
+	subInfo cmodel.SubInfo
+	dedup   []cmodel.DedupReport
+	// profile 第一个 clash 配置订阅中节点以外的内容，alias 为其节点名称到 tag 的对应
+	profile  *clashProfile
+	alias    map[string]string
+	warnings []string
+	// rename 全局重命名前后的节点名称，用于修改请求中策略组的成员
+	rename map[string]string
+}
 
+func convert2sing(cxt context.Context, client *http.Client, config []byte,
+	subList []cmodel.Subscription, include, exclude string, addTag bool, l *slog.Logger, urlTestOut bool, outFields bool, ver model.SingBoxVer, rn *renamer, dedup string, keepProfile, clashGroups bool) (convertResult, error) {
 	nodes, err := getExtTag(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("convert2sing: %w", err)
+		return convertResult{}, fmt.Errorf("convert2sing: %w: %w", ErrTemplate, err)
 	}
-	outs := make([]map[string]any, 0, len(nodes)+len(singList))
-	extTag := make([]string, 0, len(nodes)+len(tags))
+	outs := make([]map[string]any, 0, len(nodes))
+	extTag := make([]string, 0, len(nodes))
+	tplTag := make([]string, 0, len(nodes))
 
 	for _, v := range nodes {
 		outs = append(outs, v.node)
+		tplTag = append(tplTag, v.tag)
 		if v.nodeType != "urltest" && v.nodeType != "selector" {
 			extTag = append(extTag, v.tag)
 		}
 	}
 
-	s, err := convert.Clash2sing(c, ver)
+	client, ht := withHeaderTransport(client)
+	subs, err := fetchSubs(cxt, client, subList, addTag, ver, l, tplTag, keepProfile)
 	if err != nil {
-		l.DebugContext(cxt, err.Error())
+		return convertResult{}, fmt.Errorf("convert2sing: %w", err)
+	}
+
+	subs, dedupReport := dedupNodes(subs, dedup)
+	s := []singbox.SingBoxOut{}
+	singList := []map[string]any{}
+	tags := []string{}
+	for _, v := range subs {
+		s = append(s, v.s...)
+		singList = append(singList, v.singList...)
+		tags = append(tags, v.tags...)
+	}
+
+	tags, rename := renameNodes(s, singList, tags, rn, tplTag)
+	r := convertResult{dedup: dedupReport, rename: memberRename(rename, tplTag)}
+	for _, v := range outs {
+		renameOutbounds(v, r.rename)
+	}
+	for _, v := range subs {
+		if v.profile != nil {
+			v.applyAlias(rename)
+			r.profile, r.alias = v.profile, v.alias
+			break
+		}
 	}
 	outs = append(outs, singList...)
 	extTag = append(extTag, tags...)
 
-	s, outs, extTagWithV := urlTestDetourSet(s, config, outs, extTag)
+	var topGroups []string
+	if clashGroups {
+		groups, hidden, top, w := clashGroupOutbounds(r.profile, r.alias, s, tplTag)
+		outs = append(outs, groups...)
+		s = append(s, hidden...)
+		topGroups = top
+		r.warnings = append(r.warnings, w...)
+	}
+
+	s, outs, extTagWithV := urlTestDetourSet(s, outs, extTag)
 
 	nb, err := convert.PatchMap([]byte(config), s, include, exclude, lo.Map(outs, func(item map[string]any, index int) any {
 		return item
 	}), extTag, urlTestOut, outFields)
 	if err != nil {
-		return nil, nil, fmt.Errorf("convert2sing: %w", err)
+		var serr *syntax.Error
+		if errors.As(err, &serr) {
+			return convertResult{}, fmt.Errorf("convert2sing: %w: %w", ErrFilter, err)
+		}
+		return convertResult{}, fmt.Errorf("convert2sing: %w: %w", ErrTemplate, err)
 	}
 	nodeTag := make([]TagWithVisible, 0, len(s)+len(extTagWithV))
 
@@ -68,11 +118,32 @@ func convert2sing(cxt context.Context, client *http.Client, config []byte,
 		})
 	}
 	nodeTag = append(nodeTag, extTagWithV...)
-	return nb, nodeTag, nil
+	if len(topGroups) != 0 {
+		outbounds := utils.AnyGet[[]any](nb, "outbounds")
+		insertMainSelector(outbounds, topGroups, lo.SliceToMap(nodeTag, func(item TagWithVisible) (string, struct{}) {
+			return item.Tag, struct{}{}
+		}))
+	}
+	r.config = nb
+	r.nodeTag = nodeTag
+	r.subInfo = ht.subInfo(lo.Map(subList, func(item cmodel.Subscription, index int) string {
+		return item.URL
+	}))
+	return r, nil
 }
 
 var ErrFormat = errors.New("错误的格式")
 
+// 转换出错的分类，handle 根据这些错误返回不同的状态码
+var (
+	ErrUpstream   = errors.New("拉取订阅失败")
+	ErrTemplate   = errors.New("模板错误")
+	ErrFilter     = errors.New("过滤正则错误")
+	ErrProxyGroup = errors.New("策略组错误")
+	ErrRuleSet    = errors.New("规则集错误")
+	ErrOverlay    = errors.New("补丁错误")
+)
+
 var notNeedTag = map[string]struct{}{
 	"direct":  {},
 	"block":   {},
@@ -114,18 +185,36 @@ func getExtTag(config []byte) ([]extTag, error) {
 	return nodes, nil
 }
 
+// outboundTag PatchMap 生成的 outbounds 中同时有 map 和 singbox.SingBoxOut
+func outboundTag(v any) string {
+	if s, ok := v.(singbox.SingBoxOut); ok {
+		return s.Tag
+	}
+	return utils.AnyGet[string](v, "tag")
+}
+
+func outboundType(v any) string {
+	if s, ok := v.(singbox.SingBoxOut); ok {
+		return s.Type
+	}
+	return utils.AnyGet[string](v, "type")
+}
+
 type TagWithVisible struct {
 	Tag     string
 	Visible []string
 }
 
-func urlTestDetourSet(s []singbox.SingBoxOut, config []byte, outs []map[string]any, extTag []string) ([]singbox.SingBoxOut, []map[string]any, []TagWithVisible) {
-	j := gjson.ParseBytes(config)
+// urlTestDetourSet 为带 detour 的策略组生成链式节点，策略组来自 outs 中模板的出站以及 clash 的 relay
+func urlTestDetourSet(s []singbox.SingBoxOut, outs []map[string]any, extTag []string) ([]singbox.SingBoxOut, []map[string]any, []TagWithVisible) {
 	newSingOut := make([]singbox.SingBoxOut, 0)
 	newAnyOut := make([]map[string]any, 0)
 	newExtTag := make([]TagWithVisible, 0)
 
-	list := j.Get("outbounds.#(outbounds)#").Array()
+	list := lo.Filter(outs, func(item map[string]any, index int) bool {
+		_, ok := item["outbounds"]
+		return ok
+	})
 
 	update := atomic.Bool{}
 
@@ -167,8 +256,8 @@ func urlTestDetourSet(s []singbox.SingBoxOut, config []byte, outs []map[string]a
 	})
 
 	for _, value := range list {
-		detour := value.Get("detour").String()
-		tag := value.Get("tag").String()
+		detour := utils.AnyGet[string](value, "detour")
+		tag := utils.AnyGet[string](value, "tag")
 		if detour != "" {
 			m := mapF()
 			notAdd := map[string]struct{}{}
@@ -194,12 +283,13 @@ func urlTestDetourSet(s []singbox.SingBoxOut, config []byte, outs []map[string]a
 					} else {
 						singDetour.Detour = prevTag
 					}
+					singDetour.Ignored = false
 					if i == 0 {
 						singDetour.Visible = []string{tag}
 					} else {
 						singDetour.Visible = []string{"_hide"}
 					}
-					prevTag = fmt.Sprintf("%v - %v [%v]", nowTag, singDetour.Tag, tag)
+					prevTag = chainTag(nowTag, singDetour.Tag, tag)
 					singDetour.Tag = prevTag
 					newSingOut = append(newSingOut, singDetour)
 				}
@@ -211,7 +301,7 @@ func urlTestDetourSet(s []singbox.SingBoxOut, config []byte, outs []map[string]a
 					} else {
 						utils.AnySet(&anyDetour, prevTag, "detour")
 					}
-					prevTag = fmt.Sprintf("%v - %v [%v]", nowTag, utils.AnyGet[string](anyDetour, "tag"), tag)
+					prevTag = chainTag(nowTag, utils.AnyGet[string](anyDetour, "tag"), tag)
 					if i == 0 {
 						newExtTag = append(newExtTag, TagWithVisible{
 							Tag:     prevTag,
@@ -243,6 +333,11 @@ func urlTestDetourSet(s []singbox.SingBoxOut, config []byte, outs []map[string]a
 	return s, outs, tagV
 }
 
+// chainTag 链式节点的名称，relay 复制的中间节点已经带有策略组名称，不再重复添加
+func chainTag(node, hop, group string) string {
+	return fmt.Sprintf("%v - %v [%v]", node, strings.TrimSuffix(hop, " ["+group+"]"), group)
+}
+
 func singDetourList(detour string, singMap map[string]singbox.SingBoxOut) ([]string, []singbox.SingBoxOut) {
 	tags := []string{}
 	singOut := []singbox.SingBoxOut{}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+// 重复节点保留哪一个
+const (
+	DedupFirst    = "first"
+	DedupShortest = "shortest"
+)
+
+type dedupNode struct {
+	key   string
+	tag   string
+	sub   int
+	index int
+}
+
+// dedupNodes 按协议、服务器、端口和认证信息找出重复的节点，每组只保留一个。
+// 使用 detour 的节点以及被 detour 引用的节点不参与去重，避免留下断开的链。
+func dedupNodes(subs []subNodes, prefer string) ([]subNodes, []model.DedupReport) {
+	if prefer == "" {
+		return subs, nil
+	}
+
+	detour := map[string]struct{}{}
+	for _, sub := range subs {
+		for _, v := range sub.s {
+			if v.Detour != "" {
+				detour[v.Tag] = struct{}{}
+				detour[v.Detour] = struct{}{}
+			}
+		}
+		for _, v := range sub.singList {
+			if d := utils.AnyGet[string](v, "detour"); d != "" {
+				detour[utils.AnyGet[string](v, "tag")] = struct{}{}
+				detour[d] = struct{}{}
+			}
+		}
+	}
+
+	groups := map[string][]dedupNode{}
+	keys := []string{}
+	add := func(n dedupNode) {
+		if _, ok := detour[n.tag]; ok {
+			return
+		}
+		if _, ok := groups[n.key]; !ok {
+			keys = append(keys, n.key)
+		}
+		groups[n.key] = append(groups[n.key], n)
+	}
+
+	for i, sub := range subs {
+		for j, v := range sub.s {
+			if v.Ignored {
+				continue
+			}
+			add(dedupNode{
+				key:   nodeKey(v.Type, v.Server, v.ServerPort, v.Username, v.Password, v.Method, v.UUID, v.AuthStr),
+				tag:   v.Tag,
+				sub:   i,
+				index: j,
+			})
+		}
+		for j, v := range sub.singList {
+			add(dedupNode{
+				key: nodeKey(utils.AnyGet[string](v, "type"), utils.AnyGet[string](v, "server"), int(utils.AnyGet[float64](v, "server_port")),
+					utils.AnyGet[string](v, "username"), utils.AnyGet[string](v, "password"), utils.AnyGet[string](v, "method"),
+					utils.AnyGet[string](v, "uuid"), utils.AnyGet[string](v, "auth_str")),
+				tag:   utils.AnyGet[string](v, "tag"),
+				sub:   i,
+				index: len(sub.s) + j,
+			})
+		}
+	}
+
+	removed := map[int]map[int]struct{}{}
+	replaced := map[string]string{}
+	report := []model.DedupReport{}
+	for _, k := range keys {
+		g := groups[k]
+		if len(g) < 2 {
+			continue
+		}
+		keep := 0
+		if prefer == DedupShortest {
+			for i, v := range g {
+				if utf8.RuneCountInString(v.tag) < utf8.RuneCountInString(g[keep].tag) {
+					keep = i
+				}
+			}
+		}
+		r := model.DedupReport{Kept: g[keep].tag}
+		for i, v := range g {
+			if i == keep {
+				continue
+			}
+			if removed[v.sub] == nil {
+				removed[v.sub] = map[int]struct{}{}
+			}
+			removed[v.sub][v.index] = struct{}{}
+			replaced[v.tag] = g[keep].tag
+			r.Removed = append(r.Removed, v.tag)
+		}
+		report = append(report, r)
+	}
+	if len(report) == 0 {
+		return subs, nil
+	}
+
+	for i, sub := range subs {
+		subs[i].applyAlias(replaced)
+		rm := removed[i]
+		if len(rm) == 0 {
+			continue
+		}
+		s := make([]singbox.SingBoxOut, 0, len(sub.s))
+		for j, v := range sub.s {
+			if _, ok := rm[j]; !ok {
+				s = append(s, v)
+			}
+		}
+		singList := make([]map[string]any, 0, len(sub.singList))
+		removedTag := map[string]struct{}{}
+		for j, v := range sub.singList {
+			if _, ok := rm[len(sub.s)+j]; ok {
+				removedTag[utils.AnyGet[string](v, "tag")] = struct{}{}
+				continue
+			}
+			singList = append(singList, v)
+		}
+		tags := make([]string, 0, len(sub.tags))
+		for _, v := range sub.tags {
+			if _, ok := removedTag[v]; !ok {
+				tags = append(tags, v)
+			}
+		}
+		subs[i].s = s
+		subs[i].singList = singList
+		subs[i].tags = tags
+	}
+	return subs, report
+}
+
+func nodeKey(t, server string, port int, cred ...string) string {
+	return fmt.Sprintf("%s|%s|%s|%s", t, strings.ToLower(server), strconv.Itoa(port), strings.Join(cred, "|"))
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+func TestDedupNodes(t *testing.T) {
+	ss := func(tag, server string) singbox.SingBoxOut {
+		return singbox.SingBoxOut{Type: "shadowsocks", Tag: tag, Server: server, ServerPort: 443, Method: "aes-128-gcm", Password: "p"}
+	}
+	newSubs := func() []subNodes {
+		chain := ss("chain", "a.com")
+		chain.Detour = "HK long name"
+		return []subNodes{
+			{
+				s:     []singbox.SingBoxOut{ss("HK long name", "a.com"), ss("US", "b.com"), chain},
+				alias: map[string]string{"HK long name": "HK long name", "US": "US", "chain": "chain"},
+			},
+			{
+				s: []singbox.SingBoxOut{ss("HK", "A.com"), ss("US 2", "b.com"), ss("JP", "c.com")},
+				singList: []map[string]any{
+					{"type": "shadowsocks", "tag": "US 3", "server": "b.com", "server_port": float64(443), "method": "aes-128-gcm", "password": "p"},
+				},
+				tags:  []string{"US 3"},
+				alias: map[string]string{"HK": "HK", "US 2": "US 2", "JP": "JP", "US 3": "US 3"},
+			},
+		}
+	}
+	tests := []struct {
+		prefer string
+		tags   [][]string
+		report []model.DedupReport
+		alias  map[string]string
+	}{
+		{
+			prefer: "",
+			tags:   [][]string{{"HK long name", "US", "chain"}, {"HK", "US 2", "JP", "US 3"}},
+		},
+		{
+			// HK long name 被 chain 使用，不参与去重
+			prefer: DedupFirst,
+			tags:   [][]string{{"HK long name", "US", "chain"}, {"HK", "JP"}},
+			report: []model.DedupReport{{Kept: "US", Removed: []string{"US 2", "US 3"}}},
+			alias:  map[string]string{"HK": "HK", "US 2": "US", "JP": "JP", "US 3": "US"},
+		},
+		{
+			prefer: DedupShortest,
+			tags:   [][]string{{"HK long name", "US", "chain"}, {"HK", "JP"}},
+			report: []model.DedupReport{{Kept: "US", Removed: []string{"US 2", "US 3"}}},
+			alias:  map[string]string{"HK": "HK", "US 2": "US", "JP": "JP", "US 3": "US"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefer, func(t *testing.T) {
+			subs, report := dedupNodes(newSubs(), tt.prefer)
+			tags := lo.Map(subs, func(item subNodes, _ int) []string {
+				l := lo.Map(item.s, func(item singbox.SingBoxOut, _ int) string { return item.Tag })
+				return append(l, item.tags...)
+			})
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("tags = %q, want %q", tags, tt.tags)
+			}
+			if !reflect.DeepEqual(report, tt.report) {
+				t.Errorf("report = %+v, want %+v", report, tt.report)
+			}
+			if tt.alias != nil && !reflect.DeepEqual(subs[1].alias, tt.alias) {
+				t.Errorf("alias = %v, want %v", subs[1].alias, tt.alias)
+			}
+		})
+	}
+}
+
+func TestDedupNodesShortest(t *testing.T) {
+	subs := []subNodes{{s: []singbox.SingBoxOut{
+		{Type: "trojan", Tag: "香港 01 高速", Server: "a.com", ServerPort: 443, Password: "p"},
+		{Type: "trojan", Tag: "HK 01", Server: "a.com", ServerPort: 443, Password: "p"},
+		{Type: "trojan", Tag: "HK 02", Server: "a.com", ServerPort: 443, Password: "other"},
+	}}}
+	_, report := dedupNodes(subs, DedupShortest)
+	want := []model.DedupReport{{Kept: "HK 01", Removed: []string{"香港 01 高速"}}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
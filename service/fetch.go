@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"log/slog"
+
+	cmodel "github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/convert"
+	"github.com/xmdhs/clash2singbox/httputils"
+	"github.com/xmdhs/clash2singbox/model"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+	"golang.org/x/sync/errgroup"
+)
+
+// subNodes 单个订阅转换得到的节点
+type subNodes struct {
+	url      string
+	s        []singbox.SingBoxOut
+	singList []map[string]any
+	tags     []string
+	// profile 订阅为 clash 配置时的规则等内容，只在需要时读取
+	profile *clashProfile
+	// alias 订阅中原始的节点名称到当前 tag 的对应，经过重命名、去重后 clash 规则仍能找到节点
+	alias map[string]string
+}
+
+// subscriptions 将 sub 中的链接和 subs 合并为同一个列表，并去掉未启用的订阅
+func subscriptions(sub string, subs []cmodel.Subscription) []cmodel.Subscription {
+	list := []cmodel.Subscription{}
+	if sub != "" {
+		for _, v := range splitSub(sub) {
+			list = append(list, cmodel.Subscription{URL: v})
+		}
+	}
+	for _, v := range subs {
+		if v.URL == "" || !v.IsEnabled() {
+			continue
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
+// fetchSubs 逐个拉取订阅并转换，结果的顺序与 subs 相同，方便按订阅区分节点。
+// 每个订阅的过滤、重命名和标签在这里处理，reserved 为模板中已有的 tag。
+func fetchSubs(cxt context.Context, client *http.Client, subs []cmodel.Subscription, addTag bool, ver model.SingBoxVer, l *slog.Logger, reserved []string, keepProfile bool) ([]subNodes, error) {
+	list := make([]subNodes, len(subs))
+
+	g, cxt := errgroup.WithContext(cxt)
+	g.SetLimit(3)
+
+	for i, sub := range subs {
+		g.Go(func() error {
+			hc := client
+			if sub.UserAgent != "" {
+				hc = withUserAgent(client, sub.UserAgent)
+			}
+			var bt *bodyTransport
+			if keepProfile {
+				hc, bt = withBodyTransport(hc)
+			}
+			hostTag := addTag && sub.Label == ""
+			c, singList, tags, err := httputils.GetAny(cxt, hc, sub.URL, hostTag)
+			if err != nil {
+				return fmt.Errorf("fetchSubs: %w: %w", fetchErrKind(sub.URL, err), err)
+			}
+			s, err := convert.Clash2sing(c, ver)
+			if err != nil {
+				l.DebugContext(cxt, err.Error())
+			}
+			n := subNodes{
+				url:      sub.URL,
+				s:        s,
+				singList: singList,
+				tags:     tags,
+			}
+			if bt != nil && bt.body != nil {
+				n.profile = parseClashProfile(bt.body)
+			}
+			n.initAlias(hostTag)
+			err = n.filter(sub.Include, sub.Exclude)
+			if err != nil {
+				return fmt.Errorf("fetchSubs: %w", err)
+			}
+			rn, err := newRenamer(sub.Rename, false)
+			if err != nil {
+				return fmt.Errorf("fetchSubs: %w", err)
+			}
+			var rename map[string]string
+			n.tags, rename = renameNodes(n.s, n.singList, n.tags, rn, reserved)
+			n.applyAlias(rename)
+			if sub.Label != "" {
+				n.retag(func(tag string) string {
+					return fmt.Sprintf("%s[%s]", tag, sub.Label)
+				})
+			}
+			list[i] = n
+			return nil
+		})
+	}
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// fetchErrKind 链接本身无法解析或者只有无法解析的分享链接为 ErrFormat，其它错误包括订阅内容无法解析都是 ErrUpstream，
+// 只有 ErrUpstream 会使用之前缓存的配置
+func fetchErrKind(sub string, err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) && ue.Op == "parse" {
+		return ErrFormat
+	}
+	var pe httputils.Errpget
+	var ne net.Error
+	switch {
+	case errors.As(err, &pe), errors.As(err, &ne),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrUpstream
+	}
+	for _, v := range splitSub(sub) {
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			return ErrUpstream
+		}
+	}
+	return ErrFormat
+}
+
+// filter 按订阅自己的 include/exclude 过滤节点，被保留节点通过 detour 引用的节点也会保留
+func (n *subNodes) filter(include, exclude string) error {
+	if include == "" && exclude == "" {
+		return nil
+	}
+	all := make([]string, 0, len(n.s)+len(n.singList))
+	detour := map[string]string{}
+	for _, v := range n.s {
+		all = append(all, v.Tag)
+		if v.Detour != "" {
+			detour[v.Tag] = v.Detour
+		}
+	}
+	for _, v := range n.singList {
+		tag := utils.AnyGet[string](v, "tag")
+		all = append(all, tag)
+		if d := utils.AnyGet[string](v, "detour"); d != "" {
+			detour[tag] = d
+		}
+	}
+	kept, err := filterTags(all, include, exclude)
+	if err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	keep := map[string]struct{}{}
+	for _, v := range kept {
+		for {
+			if _, ok := keep[v]; ok {
+				break
+			}
+			keep[v] = struct{}{}
+			d, ok := detour[v]
+			if !ok {
+				break
+			}
+			v = d
+		}
+	}
+
+	s := make([]singbox.SingBoxOut, 0, len(n.s))
+	for _, v := range n.s {
+		if _, ok := keep[v.Tag]; ok {
+			s = append(s, v)
+		}
+	}
+	singList := make([]map[string]any, 0, len(n.singList))
+	for _, v := range n.singList {
+		if _, ok := keep[utils.AnyGet[string](v, "tag")]; ok {
+			singList = append(singList, v)
+		}
+	}
+	tags := make([]string, 0, len(n.tags))
+	for _, v := range n.tags {
+		if _, ok := keep[v]; ok {
+			tags = append(tags, v)
+		}
+	}
+	n.s, n.singList, n.tags = s, singList, tags
+	for k, v := range n.alias {
+		if _, ok := keep[v]; !ok {
+			delete(n.alias, k)
+		}
+	}
+	return nil
+}
+
+// retag 修改全部节点的 tag，并同步修改 detour
+func (n *subNodes) retag(f func(string) string) {
+	rename := map[string]string{}
+	for i := range n.s {
+		nt := f(n.s[i].Tag)
+		rename[n.s[i].Tag] = nt
+		n.s[i].Tag = nt
+	}
+	for _, v := range n.singList {
+		tag := utils.AnyGet[string](v, "tag")
+		nt := f(tag)
+		rename[tag] = nt
+		v["tag"] = nt
+	}
+	for i := range n.s {
+		if nt, ok := rename[n.s[i].Detour]; ok {
+			n.s[i].Detour = nt
+		}
+	}
+	for _, v := range n.singList {
+		if nt, ok := rename[utils.AnyGet[string](v, "detour")]; ok {
+			v["detour"] = nt
+		}
+	}
+	for i, v := range n.tags {
+		if nt, ok := rename[v]; ok {
+			n.tags[i] = nt
+		}
+	}
+	n.applyAlias(rename)
+}
+
+// initAlias addTag 时 GetAny 已经在名称后加上了域名，这里去掉后作为原始名称
+func (n *subNodes) initAlias(hostTag bool) {
+	suffix := ""
+	if hostTag {
+		if u, err := url.Parse(n.url); err == nil {
+			suffix = "[" + u.Host + "]"
+		}
+	}
+	n.alias = map[string]string{}
+	for _, v := range n.s {
+		n.alias[strings.TrimSuffix(v.Tag, suffix)] = v.Tag
+	}
+	for _, v := range n.singList {
+		tag := utils.AnyGet[string](v, "tag")
+		n.alias[strings.TrimSuffix(tag, suffix)] = tag
+	}
+}
+
+// applyAlias 节点的 tag 改变后同步修改 alias
+func (n *subNodes) applyAlias(rename map[string]string) {
+	if len(rename) == 0 {
+		return
+	}
+	for k, v := range n.alias {
+		if nt, ok := rename[v]; ok {
+			n.alias[k] = nt
+		}
+	}
+}
+
+// userAgentTransport 替换拉取订阅时的 User-Agent
+type userAgentTransport struct {
+	base http.RoundTripper
+	ua   string
+}
+
+func (u *userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("User-Agent", u.ua)
+	return u.base.RoundTrip(r)
+}
+
+func withUserAgent(c *http.Client, ua string) *http.Client {
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	nc := *c
+	nc.Transport = &userAgentTransport{base: base, ua: ua}
+	return &nc
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	cmodel "github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2singbox/httputils"
+	"github.com/xmdhs/clash2singbox/model"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+func TestFetchErrKind(t *testing.T) {
+	tests := []struct {
+		name string
+		sub  string
+		err  error
+		want error
+	}{
+		{name: "bad url", sub: "http://a b", err: &url.Error{Op: "parse", URL: "http://a b", Err: errors.New("invalid")}, want: ErrFormat},
+		{name: "status", sub: "https://a.com/sub", err: httputils.Errpget{}, want: ErrUpstream},
+		{name: "unparseable content", sub: "https://a.com/sub", err: errors.New("yaml: line 1"), want: ErrUpstream},
+		{name: "unparseable content in list", sub: "ss://x|https://a.com/sub", err: errors.New("yaml: line 1"), want: ErrUpstream},
+		{name: "bad share link", sub: "ss://x", err: fmt.Errorf("parseSs: %w", errors.New("invalid")), want: ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fetchErrKind(tt.sub, tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchSubs(t *testing.T) {
+	files := map[string]string{
+		"/a": `proxies:
+  - {name: "HK 01", type: trojan, server: a.com, port: 443, password: p}
+  - {name: "US 01", type: trojan, server: b.com, port: 443, password: p}
+  - {name: "HK 02", type: trojan, server: c.com, port: 443, password: p}
+`,
+		"/b": `proxies:
+  - {name: "HK 01", type: trojan, server: d.com, port: 443, password: p}
+  - {name: "JP 01", type: trojan, server: e.com, port: 443, password: p}
+`,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(files[r.URL.Path]))
+	}))
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+	disabled := false
+
+	tests := []struct {
+		name      string
+		sub       string
+		subs      []cmodel.Subscription
+		addTag    bool
+		want      [][]string
+		wantAlias map[string]string
+	}{
+		{
+			name: "overlapping names with different filters",
+			subs: []cmodel.Subscription{{URL: s.URL + "/a", Include: "HK"}, {URL: s.URL + "/b", Exclude: "HK"}},
+			want: [][]string{{"HK 01", "HK 02"}, {"JP 01"}},
+		},
+		{
+			name: "rename per subscription",
+			subs: []cmodel.Subscription{
+				{URL: s.URL + "/a", Rename: []cmodel.RenameRule{{Match: "^HK", Replace: "香港"}}},
+				{URL: s.URL + "/b"},
+			},
+			want:      [][]string{{"香港 01", "US 01", "香港 02"}, {"HK 01", "JP 01"}},
+			wantAlias: map[string]string{"HK 01": "香港 01", "US 01": "US 01", "HK 02": "香港 02"},
+		},
+		{
+			name:      "labels replace the host tag",
+			subs:      []cmodel.Subscription{{URL: s.URL + "/a", Label: "A", Include: "US"}, {URL: s.URL + "/b", Label: "B"}},
+			addTag:    true,
+			want:      [][]string{{"US 01[A]"}, {"HK 01[B]", "JP 01[B]"}},
+			wantAlias: map[string]string{"US 01": "US 01[A]"},
+		},
+		{
+			name:      "host tag",
+			sub:       s.URL + "/b",
+			addTag:    true,
+			want:      [][]string{{"HK 01[" + host + "]", "JP 01[" + host + "]"}},
+			wantAlias: map[string]string{"HK 01": "HK 01[" + host + "]", "JP 01": "JP 01[" + host + "]"},
+		},
+		{
+			name: "disabled subscription",
+			subs: []cmodel.Subscription{{URL: s.URL + "/a", Enabled: &disabled}, {URL: s.URL + "/b"}},
+			want: [][]string{{"HK 01", "JP 01"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := fetchSubs(context.Background(), s.Client(), subscriptions(tt.sub, tt.subs), tt.addTag, model.SINGLATEST, slog.Default(), nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := lo.Map(list, func(n subNodes, _ int) []string {
+				return lo.Map(n.s, func(item singbox.SingBoxOut, _ int) string { return item.Tag })
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tags = %q, want %q", got, tt.want)
+			}
+			if tt.wantAlias != nil && !reflect.DeepEqual(list[0].alias, tt.wantAlias) {
+				t.Errorf("alias = %v, want %v", list[0].alias, tt.wantAlias)
+			}
+		})
+	}
+}
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+// applyProxyGroups 加入策略组和它们的路由、dns 规则，返回无法按要求生成的内容
+func applyProxyGroups(config map[string]any, groups []model.ProxyGroup, nodeTag []TagWithVisible) (map[string]any, []string, error) {
+	if len(groups) == 0 {
+		return config, nil, nil
+	}
+	// include/exclude 只匹配没有限定可见范围的节点，与 configUrlTestParser 相同
+	nodes := lo.FilterMap(nodeTag, func(item TagWithVisible, _ int) (string, bool) {
+		return item.Tag, len(item.Visible) == 0
+	})
+	err := checkProxyGroups(config, groups, nodes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("applyProxyGroups: %w", err)
+	}
+	warnings := []string{}
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	route := utils.AnyGet[map[string]any](config, "route")
+	ruleSet := utils.AnyGet[[]any](route, "rule_set")
+	rules := utils.AnyGet[[]any](route, "rules")
+	fallbackRules := make([]any, 0, len(groups))
+	priorityRules := []priorityRule{}
+	dnsRules := []any{}
+
+	for _, group := range groups {
+		tag := strings.TrimSpace(group.Tag)
+		if tag == "" {
+			continue
+		}
+		groupType := strings.TrimSpace(group.Type)
+		if groupType == "" {
+			groupType = "urltest"
+		}
+
+		members := groupMembers(group)
+		include, exclude := groupDirectives(group, members)
+
+		newOutbound := map[string]any{
+			"type":      groupType,
+			"tag":       tag,
+			"outbounds": []any{},
+		}
+		switch groupType {
+		case "urltest":
+			newOutbound["url"] = lo.CoalesceOrEmpty(strings.TrimSpace(group.URL), "https://cp.cloudflare.com/generate_204")
+			newOutbound["interval"] = lo.CoalesceOrEmpty(strings.TrimSpace(group.Interval), "10m")
+			newOutbound["tolerance"] = lo.CoalesceOrEmpty(group.Tolerance, 50)
+			if group.IdleTimeout != "" {
+				newOutbound["idle_timeout"] = group.IdleTimeout
+			}
+		case "selector":
+			if group.Default != "" {
+				newOutbound["default"] = group.Default
+			}
+		}
+		if group.InterruptExistConnections {
+			newOutbound["interrupt_exist_connections"] = true
+		}
+
+		outboundItems := make([]any, 0, len(members)+2)
+		for _, v := range members {
+			outboundItems = append(outboundItems, v)
+		}
+		if include != "" {
+			outboundItems = append(outboundItems, "include: "+include)
+		}
+		if exclude != "" {
+			outboundItems = append(outboundItems, "exclude: "+exclude)
+		}
+		newOutbound["outbounds"] = outboundItems
+		outbounds = append(outbounds, newOutbound)
+
+		newSets, setTags := groupRuleSets(group, tag)
+		ruleSet = append(ruleSet, newSets...)
+		gr := groupRouteRules(group, tag, setTags)
+		if group.Priority != nil {
+			priorityRules = append(priorityRules, priorityRule{index: *group.Priority, rules: gr})
+		} else {
+			fallbackRules = append(fallbackRules, gr...)
+		}
+		if r := groupDNSRules(group, setTags, ""); len(r) != 0 {
+			server, guessed := groupDNSServer(config, group, tag)
+			switch {
+			case server == "":
+				warnings = append(warnings, fmt.Sprintf("proxyGroups: %s: no proxied dns server found, dns rules skipped, set dnsServer", tag))
+			case guessed:
+				warnings = append(warnings, fmt.Sprintf("proxyGroups: %s: dnsServer not set, using %s", tag, server))
+			}
+			if server != "" {
+				dnsRules = append(dnsRules, groupDNSRules(group, setTags, server)...)
+			}
+		}
+	}
+
+	// 插入位置都按模板中原有的规则计算，插入的规则不影响其他策略组的位置
+	inserts := make([][]any, len(rules)+1)
+	for _, v := range priorityRules {
+		i := v.index
+		if i < 0 {
+			i = len(rules) + 1 + i
+		}
+		i = min(max(i, 0), len(rules))
+		inserts[i] = append(inserts[i], v.rules...)
+	}
+	if len(fallbackRules) > 0 {
+		insertIndex := len(rules)
+		for i, rule := range rules {
+			if isDirectFallbackRule(rule) {
+				insertIndex = i
+				break
+			}
+		}
+		inserts[insertIndex] = append(inserts[insertIndex], fallbackRules...)
+	}
+	newRules := make([]any, 0, len(rules)+len(fallbackRules))
+	for i, v := range inserts {
+		newRules = append(newRules, v...)
+		if i < len(rules) {
+			newRules = append(newRules, rules[i])
+		}
+	}
+	rules = newRules
+
+	utils.AnySet(&config, outbounds, "outbounds")
+	utils.AnySet(&route, ruleSet, "rule_set")
+	utils.AnySet(&route, rules, "rules")
+	utils.AnySet(&config, route, "route")
+	if len(dnsRules) > 0 {
+		config = insertDNSRules(config, dnsRules)
+	}
+	return config, warnings, nil
+}
+
+type priorityRule struct {
+	index int
+	rules []any
+}
+
+// groupRuleSets 生成策略组新增的规则集，返回新增的规则集和规则中引用的全部规则集 tag
+func groupRuleSets(group model.ProxyGroup, tag string) ([]any, []string) {
+	sets := slices.Clone(group.RuleSets)
+	if srsURL := strings.TrimSpace(group.SrsURL); srsURL != "" {
+		sets = slices.Insert(sets, 0, model.GroupRuleSet{Tag: tag + "-rule-set", URL: srsURL, Format: "binary"})
+	}
+
+	newSets := []any{}
+	tags := []string{}
+	for i, v := range sets {
+		setTag := strings.TrimSpace(v.Tag)
+		t := ruleSetType(v)
+		if t == "" {
+			// 引用模板中已有的规则集
+			tags = append(tags, setTag)
+			continue
+		}
+		if setTag == "" {
+			setTag = fmt.Sprintf("%s-rule-set-%d", tag, i+1)
+		}
+		m := map[string]any{
+			"tag":  setTag,
+			"type": t,
+		}
+		if t == "inline" {
+			m["rules"] = lo.ToAnySlice(v.Rules)
+		} else {
+			m["format"] = ruleSetFormat(v)
+			m["url"] = strings.TrimSpace(v.URL)
+			if group.DownloadDetour != "" {
+				m["download_detour"] = group.DownloadDetour
+			}
+			if group.UpdateInterval != "" {
+				m["update_interval"] = group.UpdateInterval
+			}
+		}
+		newSets = append(newSets, m)
+		tags = append(tags, setTag)
+	}
+	return newSets, tags
+}
+
+func ruleSetType(v model.GroupRuleSet) string {
+	if v.Type != "" {
+		return v.Type
+	}
+	if strings.TrimSpace(v.URL) != "" {
+		return "remote"
+	}
+	if len(v.Rules) != 0 {
+		return "inline"
+	}
+	return ""
+}
+
+func ruleSetFormat(v model.GroupRuleSet) string {
+	if v.Format != "" {
+		return v.Format
+	}
+	u := strings.TrimSpace(v.URL)
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		u = u[:i]
+	}
+	if strings.HasSuffix(u, ".json") {
+		return "source"
+	}
+	return "binary"
+}
+
+// groupRouteRules 生成分流到策略组的规则。
+// sing-box 中 process_name 与域名、ip 之间是且的关系，所以分开成多条规则。
+func groupRouteRules(group model.ProxyGroup, tag string, setTags []string) []any {
+	rules := []any{}
+	if len(setTags) != 0 {
+		rules = append(rules, map[string]any{
+			"rule_set": lo.ToAnySlice(setTags),
+			"outbound": tag,
+		})
+	}
+	if r := domainRule(group, true); len(r) != 0 {
+		r["outbound"] = tag
+		rules = append(rules, r)
+	}
+	if len(group.ProcessName) != 0 {
+		rules = append(rules, map[string]any{
+			"process_name": lo.ToAnySlice(group.ProcessName),
+			"outbound":     tag,
+		})
+	}
+	return rules
+}
+
+func domainRule(group model.ProxyGroup, ip bool) map[string]any {
+	r := map[string]any{}
+	set := func(k string, v []string) {
+		if len(v) != 0 {
+			r[k] = lo.ToAnySlice(v)
+		}
+	}
+	set("domain", group.Domain)
+	set("domain_suffix", group.DomainSuffix)
+	set("domain_keyword", group.DomainKeyword)
+	if ip {
+		set("ip_cidr", group.IPCIDR)
+	}
+	return r
+}
+
+func groupDNSRules(group model.ProxyGroup, setTags []string, server string) []any {
+	rules := []any{}
+	if len(setTags) != 0 {
+		rules = append(rules, map[string]any{
+			"rule_set": lo.ToAnySlice(setTags),
+			"server":   server,
+		})
+	}
+	if r := domainRule(group, false); len(r) != 0 {
+		r["server"] = server
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// groupDNSServer 优先使用 detour 为此策略组的 dns 服务器，其次是第一个经过代理的服务器，
+// 使用后者时 guessed 为 true
+func groupDNSServer(config map[string]any, group model.ProxyGroup, tag string) (server string, guessed bool) {
+	if group.DNSServer != "" {
+		return group.DNSServer, false
+	}
+	servers := utils.AnyGet[[]any](utils.AnyGet[map[string]any](config, "dns"), "servers")
+	for _, v := range servers {
+		detour := utils.AnyGet[string](v, "detour")
+		if detour == tag {
+			return utils.AnyGet[string](v, "tag"), false
+		}
+		if server == "" && detour != "" && detour != "direct" {
+			server = utils.AnyGet[string](v, "tag")
+		}
+	}
+	return server, server != ""
+}
+
+// insertDNSRules 插入到第一条按域名或规则集匹配的 dns 规则之前，
+// 这样 clash_mode、fakeip 等模板中的规则仍然优先
+func insertDNSRules(config map[string]any, rules []any) map[string]any {
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	if dns == nil {
+		return config
+	}
+	dnsRules := utils.AnyGet[[]any](dns, "rules")
+	i := slices.IndexFunc(dnsRules, func(item any) bool {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return false
+		}
+		for _, k := range []string{"rule_set", "domain", "domain_suffix", "domain_keyword", "domain_regex", "geosite"} {
+			if _, ok := m[k]; ok {
+				return true
+			}
+		}
+		return false
+	})
+	if i == -1 {
+		i = len(dnsRules)
+	}
+	utils.AnySet(&dns, slices.Insert(dnsRules, i, rules...), "rules")
+	utils.AnySet(&config, dns, "dns")
+	return config
+}
+
+func groupMembers(group model.ProxyGroup) []string {
+	return lo.Uniq(lo.FilterMap(group.Outbounds, func(item string, _ int) (string, bool) {
+		item = strings.TrimSpace(item)
+		return item, item != ""
+	}))
+}
+
+// groupDirectives 策略组的 include 和 exclude，两者和成员都为空时包含全部节点
+func groupDirectives(group model.ProxyGroup, members []string) (string, string) {
+	include := strings.TrimSpace(group.Include)
+	exclude := strings.TrimSpace(group.Exclude)
+	if include == "" && exclude == "" && len(members) == 0 {
+		include = ".*"
+	}
+	return include, exclude
+}
+
+// checkProxyGroups 检查策略组的 tag 是否重复，引用的出站、规则集和 dns 服务器是否存在，
+// default 是否为策略组的成员，以及策略组之间是否循环引用。nodes 为 include/exclude 可以匹配的节点
+func checkProxyGroups(config map[string]any, groups []model.ProxyGroup, nodes []string) error {
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	exist := map[string]struct{}{}
+	// 模板和新增策略组的成员，用于检查循环引用
+	graph := map[string][]string{}
+	for _, v := range outbounds {
+		tag := outboundTag(v)
+		exist[tag] = struct{}{}
+		switch o := v.(type) {
+		case singbox.SingBoxOut:
+			graph[tag] = o.Outbounds
+		case map[string]any:
+			graph[tag] = lo.FilterMap(utils.AnyGet[[]any](o, "outbounds"), func(item any, _ int) (string, bool) {
+				s, ok := item.(string)
+				return s, ok
+			})
+		}
+	}
+
+	ruleSets := map[string]struct{}{}
+	for _, v := range utils.AnyGet[[]any](utils.AnyGet[map[string]any](config, "route"), "rule_set") {
+		ruleSets[utils.AnyGet[string](v, "tag")] = struct{}{}
+	}
+	dnsServers := map[string]struct{}{}
+	for _, v := range utils.AnyGet[[]any](utils.AnyGet[map[string]any](config, "dns"), "servers") {
+		dnsServers[utils.AnyGet[string](v, "tag")] = struct{}{}
+	}
+
+	groupTag := map[string]struct{}{}
+	for _, g := range groups {
+		tag := strings.TrimSpace(g.Tag)
+		if tag == "" {
+			continue
+		}
+		if _, ok := exist[tag]; ok {
+			return fmt.Errorf("checkProxyGroups: %w: duplicate tag %q", ErrProxyGroup, tag)
+		}
+		if _, ok := groupTag[tag]; ok {
+			return fmt.Errorf("checkProxyGroups: %w: duplicate tag %q", ErrProxyGroup, tag)
+		}
+		groupTag[tag] = struct{}{}
+		switch t := strings.TrimSpace(g.Type); t {
+		case "", "urltest", "selector":
+		default:
+			return fmt.Errorf("checkProxyGroups: %w: %q unsupported type %q", ErrProxyGroup, tag, t)
+		}
+	}
+
+	known := func(tag string) bool {
+		_, ok1 := exist[tag]
+		_, ok2 := groupTag[tag]
+		return ok1 || ok2
+	}
+	for _, g := range groups {
+		tag := strings.TrimSpace(g.Tag)
+		if tag == "" {
+			continue
+		}
+		members := groupMembers(g)
+		for _, v := range members {
+			if !known(v) {
+				return fmt.Errorf("checkProxyGroups: %w: %q references unknown outbound %q", ErrProxyGroup, tag, v)
+			}
+		}
+		if g.Default != "" {
+			if t := strings.TrimSpace(g.Type); t != "selector" {
+				return fmt.Errorf("checkProxyGroups: %w: %q default only works with selector", ErrProxyGroup, tag)
+			}
+			if !known(g.Default) {
+				return fmt.Errorf("checkProxyGroups: %w: %q default references unknown outbound %q", ErrProxyGroup, tag, g.Default)
+			}
+			if !slices.Contains(members, g.Default) {
+				include, exclude := groupDirectives(g, members)
+				matched := false
+				if (include != "" || exclude != "") && slices.Contains(nodes, g.Default) {
+					kept, err := filterTags([]string{g.Default}, include, exclude)
+					if err != nil {
+						return fmt.Errorf("checkProxyGroups: %w", err)
+					}
+					matched = len(kept) != 0
+				}
+				if !matched {
+					return fmt.Errorf("checkProxyGroups: %w: %q default %q is not a member of the group", ErrProxyGroup, tag, g.Default)
+				}
+			}
+		}
+		graph[tag] = members
+
+		if g.DownloadDetour != "" && !known(g.DownloadDetour) {
+			return fmt.Errorf("checkProxyGroups: %w: %q download_detour references unknown outbound %q", ErrProxyGroup, tag, g.DownloadDetour)
+		}
+		if g.DNSServer != "" {
+			if _, ok := dnsServers[g.DNSServer]; !ok {
+				return fmt.Errorf("checkProxyGroups: %w: %q references unknown dns server %q", ErrProxyGroup, tag, g.DNSServer)
+			}
+		}
+		newSets, _ := groupRuleSets(g, tag)
+		for _, v := range g.RuleSets {
+			setTag := strings.TrimSpace(v.Tag)
+			switch ruleSetType(v) {
+			case "":
+				if _, ok := ruleSets[setTag]; !ok {
+					return fmt.Errorf("checkProxyGroups: %w: %q references unknown rule_set %q", ErrProxyGroup, tag, setTag)
+				}
+			case "remote":
+				if strings.TrimSpace(v.URL) == "" {
+					return fmt.Errorf("checkProxyGroups: %w: %q remote rule_set without url", ErrProxyGroup, tag)
+				}
+				if f := ruleSetFormat(v); f != "binary" && f != "source" {
+					return fmt.Errorf("checkProxyGroups: %w: %q unsupported rule_set format %q", ErrProxyGroup, tag, f)
+				}
+			case "inline":
+				if len(v.Rules) == 0 {
+					return fmt.Errorf("checkProxyGroups: %w: %q inline rule_set without rules", ErrProxyGroup, tag)
+				}
+			default:
+				return fmt.Errorf("checkProxyGroups: %w: %q unsupported rule_set type %q", ErrProxyGroup, tag, v.Type)
+			}
+		}
+		for _, v := range newSets {
+			setTag := utils.AnyGet[string](v, "tag")
+			if _, ok := ruleSets[setTag]; ok {
+				return fmt.Errorf("checkProxyGroups: %w: duplicate rule_set tag %q", ErrProxyGroup, setTag)
+			}
+			ruleSets[setTag] = struct{}{}
+		}
+	}
+
+	// 0 未访问，1 正在访问，2 已完成
+	state := map[string]int{}
+	var visit func(tag string, path []string) error
+	visit = func(tag string, path []string) error {
+		switch state[tag] {
+		case 1:
+			return fmt.Errorf("checkProxyGroups: %w: cycle %s", ErrProxyGroup, strings.Join(append(path, tag), " -> "))
+		case 2:
+			return nil
+		}
+		state[tag] = 1
+		for _, v := range graph[tag] {
+			err := visit(v, append(path, tag))
+			if err != nil {
+				return err
+			}
+		}
+		state[tag] = 2
+		return nil
+	}
+	for _, g := range groups {
+		tag := strings.TrimSpace(g.Tag)
+		if tag == "" {
+			continue
+		}
+		err := visit(tag, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDirectFallbackRule(rule any) bool {
+	ruleMap, ok := rule.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	if utils.AnyGet[string](ruleMap, "outbound") != "direct" {
+		return false
+	}
+
+	if ipIsPrivate, ok := ruleMap["ip_is_private"].(bool); ok && ipIsPrivate {
+		return true
+	}
+
+	return utils.AnyGet[string](ruleMap, "rule_set") == "geoip-cn"
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+const groupConfig = `{
+	"dns":{"servers":[{"tag":"local","address":"local","detour":"direct"},{"tag":"remote","address":"tls://8.8.8.8","detour":"select"}],
+		"rules":[{"outbound":"any","server":"local"},{"rule_set":"geosite-cn","server":"local"}]},
+	"outbounds":[
+		{"type":"selector","tag":"select","outbounds":["direct"]},
+		{"type":"direct","tag":"direct"},
+		{"type":"trojan","tag":"HK 1","server":"a.com","server_port":443,"password":"p"},
+		{"type":"trojan","tag":"US 1","server":"b.com","server_port":443,"password":"p"}
+	],
+	"route":{"rule_set":[{"tag":"geosite-cn","type":"remote","url":"https://a.com/cn.srs"}],
+		"rules":[{"protocol":"dns","action":"hijack-dns"},{"ip_is_private":true,"outbound":"direct"},{"rule_set":"geosite-cn","outbound":"direct"}]}
+}`
+
+func TestCheckProxyGroups(t *testing.T) {
+	nodes := []string{"HK 1", "US 1"}
+	tests := []struct {
+		name    string
+		groups  []model.ProxyGroup
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			groups: []model.ProxyGroup{
+				{Tag: "a", Type: "selector", Outbounds: []string{"b", "direct"}, Default: "direct"},
+				{Tag: "b", Include: "HK"},
+			},
+		},
+		{
+			name:   "default matched by include",
+			groups: []model.ProxyGroup{{Tag: "a", Type: "selector", Include: "HK", Default: "HK 1"}},
+		},
+		{
+			name:   "default matched by all nodes",
+			groups: []model.ProxyGroup{{Tag: "a", Type: "selector", Default: "US 1"}},
+		},
+		{
+			name:    "default excluded",
+			groups:  []model.ProxyGroup{{Tag: "a", Type: "selector", Include: "HK", Default: "US 1"}},
+			wantErr: true,
+		},
+		{
+			name:    "default not a member",
+			groups:  []model.ProxyGroup{{Tag: "a", Type: "selector", Outbounds: []string{"select"}, Default: "direct"}},
+			wantErr: true,
+		},
+		{
+			name:    "default on urltest",
+			groups:  []model.ProxyGroup{{Tag: "a", Outbounds: []string{"direct"}, Default: "direct"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate tag",
+			groups:  []model.ProxyGroup{{Tag: "select"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown outbound",
+			groups:  []model.ProxyGroup{{Tag: "a", Outbounds: []string{"none"}}},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			groups: []model.ProxyGroup{
+				{Tag: "a", Type: "selector", Outbounds: []string{"b"}},
+				{Tag: "b", Type: "selector", Outbounds: []string{"a"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown dns server",
+			groups:  []model.ProxyGroup{{Tag: "a", DNSServer: "none"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown rule set",
+			groups:  []model.ProxyGroup{{Tag: "a", RuleSets: []model.GroupRuleSet{{Tag: "none"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate rule set",
+			groups:  []model.ProxyGroup{{Tag: "a", RuleSets: []model.GroupRuleSet{{Tag: "geosite-cn", URL: "https://a.com/b.srs"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported rule set format",
+			groups:  []model.ProxyGroup{{Tag: "a", RuleSets: []model.GroupRuleSet{{URL: "https://a.com/b.srs", Format: "yaml"}}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProxyGroups(jsonMap(t, groupConfig), tt.groups, nodes)
+			if tt.wantErr {
+				if !errors.Is(err, ErrProxyGroup) {
+					t.Fatalf("err = %v, want ErrProxyGroup", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestApplyProxyGroups(t *testing.T) {
+	nodes := []TagWithVisible{{Tag: "HK 1"}, {Tag: "US 1"}}
+	zero, last := 0, -1
+	tests := []struct {
+		name     string
+		groups   []model.ProxyGroup
+		want     string
+		warnings int
+	}{
+		{
+			name: "fallback and priority rules",
+			groups: []model.ProxyGroup{
+				{Tag: "ai", Include: "US", DomainSuffix: []string{"openai.com"}, ProcessName: []string{"chatgpt"}},
+				{Tag: "first", Type: "selector", Outbounds: []string{"select"}, Priority: &zero, IPCIDR: []string{"1.1.1.1/32"}},
+				{Tag: "end", Type: "selector", Outbounds: []string{"select"}, Priority: &last, Domain: []string{"b.com"}},
+			},
+			want: `{
+				"dns":{"servers":[{"tag":"local","address":"local","detour":"direct"},{"tag":"remote","address":"tls://8.8.8.8","detour":"select"}],
+					"rules":[{"outbound":"any","server":"local"},
+						{"domain_suffix":["openai.com"],"server":"remote"},
+						{"domain":["b.com"],"server":"remote"},
+						{"rule_set":"geosite-cn","server":"local"}]},
+				"outbounds":[
+					{"type":"selector","tag":"select","outbounds":["direct"]},
+					{"type":"direct","tag":"direct"},
+					{"type":"trojan","tag":"HK 1","server":"a.com","server_port":443,"password":"p"},
+					{"type":"trojan","tag":"US 1","server":"b.com","server_port":443,"password":"p"},
+					{"type":"urltest","tag":"ai","outbounds":["include: US"],"url":"https://cp.cloudflare.com/generate_204","interval":"10m","tolerance":50},
+					{"type":"selector","tag":"first","outbounds":["select"]},
+					{"type":"selector","tag":"end","outbounds":["select"]}
+				],
+				"route":{"rule_set":[{"tag":"geosite-cn","type":"remote","url":"https://a.com/cn.srs"}],
+					"rules":[
+						{"ip_cidr":["1.1.1.1/32"],"outbound":"first"},
+						{"protocol":"dns","action":"hijack-dns"},
+						{"domain_suffix":["openai.com"],"outbound":"ai"},
+						{"process_name":["chatgpt"],"outbound":"ai"},
+						{"ip_is_private":true,"outbound":"direct"},
+						{"rule_set":"geosite-cn","outbound":"direct"},
+						{"domain":["b.com"],"outbound":"end"}
+					]}
+			}`,
+			warnings: 2,
+		},
+		{
+			name: "rule sets and dns server",
+			groups: []model.ProxyGroup{{
+				Tag: "g", Type: "selector", Outbounds: []string{"select"}, Default: "select",
+				SrsURL:         "https://a.com/g.srs",
+				RuleSets:       []model.GroupRuleSet{{Tag: "geosite-cn"}, {URL: "https://a.com/g.json"}, {Rules: []map[string]any{{"domain": []any{"c.com"}}}}},
+				DownloadDetour: "direct",
+				DNSServer:      "local",
+			}},
+			want: `{
+				"dns":{"servers":[{"tag":"local","address":"local","detour":"direct"},{"tag":"remote","address":"tls://8.8.8.8","detour":"select"}],
+					"rules":[{"outbound":"any","server":"local"},
+						{"rule_set":["g-rule-set","geosite-cn","g-rule-set-3","g-rule-set-4"],"server":"local"},
+						{"rule_set":"geosite-cn","server":"local"}]},
+				"outbounds":[
+					{"type":"selector","tag":"select","outbounds":["direct"]},
+					{"type":"direct","tag":"direct"},
+					{"type":"trojan","tag":"HK 1","server":"a.com","server_port":443,"password":"p"},
+					{"type":"trojan","tag":"US 1","server":"b.com","server_port":443,"password":"p"},
+					{"type":"selector","tag":"g","outbounds":["select"],"default":"select"}
+				],
+				"route":{"rule_set":[
+						{"tag":"geosite-cn","type":"remote","url":"https://a.com/cn.srs"},
+						{"tag":"g-rule-set","type":"remote","format":"binary","url":"https://a.com/g.srs","download_detour":"direct"},
+						{"tag":"g-rule-set-3","type":"remote","format":"source","url":"https://a.com/g.json","download_detour":"direct"},
+						{"tag":"g-rule-set-4","type":"inline","rules":[{"domain":["c.com"]}]}
+					],
+					"rules":[
+						{"protocol":"dns","action":"hijack-dns"},
+						{"rule_set":["g-rule-set","geosite-cn","g-rule-set-3","g-rule-set-4"],"outbound":"g"},
+						{"ip_is_private":true,"outbound":"direct"},
+						{"rule_set":"geosite-cn","outbound":"direct"}
+					]}
+			}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, warnings, err := applyProxyGroups(jsonMap(t, groupConfig), tt.groups, nodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, m, tt.want)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+// shareLinks 把节点转换为 v2rayN 格式的分享链接列表，经过 base64 编码。
+// include 和 exclude 在 PatchMap 中只作用于 urltest，这里再按同样的规则过滤节点。
+// 只支持 ss、vmess、vless、trojan 和 hysteria2，其他节点和链式节点返回在警告中。
+func shareLinks(config map[string]any, nodes []TagWithVisible, include, exclude string) ([]byte, []string, error) {
+	byTag := map[string]map[string]any{}
+	for _, v := range utils.AnyGet[[]any](config, "outbounds") {
+		m, err := outboundMap(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("shareLinks: %w", err)
+		}
+		byTag[utils.AnyGet[string](m, "tag")] = m
+	}
+	// 链式节点只在 detour 的策略组中使用，不单独输出
+	tags := lo.FilterMap(nodes, func(item TagWithVisible, _ int) (string, bool) {
+		return item.Tag, len(item.Visible) == 0
+	})
+	tags, err := filterTags(tags, include, exclude)
+	if err != nil {
+		return nil, nil, fmt.Errorf("shareLinks: %w", err)
+	}
+
+	links := []string{}
+	unsupported := []string{}
+	for _, tag := range tags {
+		out, ok := byTag[tag]
+		if !ok {
+			continue
+		}
+		switch utils.AnyGet[string](out, "type") {
+		case "direct", "block", "dns", "selector", "urltest":
+			continue
+		}
+		p, err := toClashProxy(out, byTag)
+		if err != nil {
+			unsupported = append(unsupported, tag)
+			continue
+		}
+		link, err := shareLink(p)
+		if err != nil {
+			unsupported = append(unsupported, tag)
+			continue
+		}
+		links = append(links, link)
+	}
+
+	warnings := []string{}
+	if len(unsupported) != 0 {
+		// 节点很多时只列出前几个，避免响应头过长
+		names := strings.Join(lo.Slice(unsupported, 0, 3), ", ")
+		if len(unsupported) > 3 {
+			names += ", ..."
+		}
+		warnings = append(warnings, fmt.Sprintf("links: %d nodes can not be expressed as links: %s", len(unsupported), names))
+	}
+	body := base64.StdEncoding.AppendEncode(nil, []byte(strings.Join(links, "\n")))
+	return body, warnings, nil
+}
+
+// shareLink 由 clash 的节点生成分享链接，字段名与 clash 的相同
+func shareLink(p clashProxy) (string, error) {
+	if p.Extra["dialer-proxy"] != nil {
+		return "", fmt.Errorf("shareLink: %s uses dialer-proxy", p.Name)
+	}
+	e := p.Extra
+	str := func(k string) string {
+		s, _ := e[k].(string)
+		return s
+	}
+	host := net.JoinHostPort(p.Server, strconv.Itoa(p.Port))
+	q := url.Values{}
+
+	switch p.Type {
+	case "ss":
+		userinfo := url.User(base64.RawURLEncoding.EncodeToString([]byte(str("cipher") + ":" + str("password"))))
+		// SIP022 的密码可能包含 : 等字符，使用不编码的形式
+		if strings.HasPrefix(str("cipher"), "2022-") {
+			userinfo = url.UserPassword(str("cipher"), str("password"))
+		}
+		if plugin := str("plugin"); plugin != "" {
+			opts, _ := e["plugin-opts"].(map[string]any)
+			s, err := ssPlugin(plugin, opts)
+			if err != nil {
+				return "", fmt.Errorf("shareLink: %w", err)
+			}
+			q.Set("plugin", s)
+		}
+		u := url.URL{Scheme: "ss", User: userinfo, Host: host, RawQuery: q.Encode(), Fragment: p.Name}
+		return u.String(), nil
+	case "vmess":
+		v := map[string]any{
+			"v":    "2",
+			"ps":   p.Name,
+			"add":  p.Server,
+			"port": p.Port,
+			"id":   str("uuid"),
+			"aid":  anyInt(e["alterId"]),
+			"scy":  lo.CoalesceOrEmpty(str("cipher"), "auto"),
+			"net":  "tcp",
+			"type": "none",
+		}
+		linkTransport(e, q)
+		v["net"] = q.Get("type")
+		v["host"] = q.Get("host")
+		v["path"] = q.Get("path")
+		switch q.Get("type") {
+		case "grpc":
+			v["path"] = q.Get("serviceName")
+		case "http":
+			v["net"] = "h2"
+		case "tcp":
+			v["type"] = lo.CoalesceOrEmpty(q.Get("headerType"), "none")
+		}
+		if e["tls"] == true {
+			v["tls"] = "tls"
+			v["sni"] = str("servername")
+			v["alpn"] = strings.Join(lo.Map(anyStrings(e["alpn"]), func(item any, _ int) string { return fmt.Sprint(item) }), ",")
+			v["fp"] = str("client-fingerprint")
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("shareLink: %w", err)
+		}
+		return "vmess://" + base64.StdEncoding.EncodeToString(b), nil
+	case "vless":
+		q.Set("encryption", "none")
+		if v := str("flow"); v != "" {
+			q.Set("flow", v)
+		}
+		linkTransport(e, q)
+		linkTLS(e, q, "servername")
+		u := url.URL{Scheme: "vless", User: url.User(str("uuid")), Host: host, RawQuery: q.Encode(), Fragment: p.Name}
+		return u.String(), nil
+	case "trojan":
+		linkTransport(e, q)
+		e["tls"] = true
+		linkTLS(e, q, "sni")
+		u := url.URL{Scheme: "trojan", User: url.User(str("password")), Host: host, RawQuery: q.Encode(), Fragment: p.Name}
+		return u.String(), nil
+	case "hysteria2":
+		if v := str("sni"); v != "" {
+			q.Set("sni", v)
+		}
+		if e["skip-cert-verify"] == true {
+			q.Set("insecure", "1")
+		}
+		if v := str("obfs"); v != "" {
+			q.Set("obfs", v)
+			q.Set("obfs-password", str("obfs-password"))
+		}
+		// 端口跳跃写在地址的端口中，如 example.com:443,20000-30000
+		if v := str("ports"); v != "" {
+			if p.Port == 0 {
+				host = net.JoinHostPort(p.Server, v)
+			} else {
+				host += "," + v
+			}
+		}
+		u := url.URL{Scheme: "hysteria2", User: url.User(str("password")), Host: host, RawQuery: q.Encode(), Fragment: p.Name}
+		return u.String(), nil
+	}
+	return "", fmt.Errorf("shareLink: unsupported type %s", p.Type)
+}
+
+// linkTLS vless 和 trojan 链接中 tls 相关的参数
+func linkTLS(e map[string]any, q url.Values, sniKey string) {
+	if e["tls"] != true {
+		q.Set("security", "none")
+		return
+	}
+	q.Set("security", "tls")
+	if v, _ := e[sniKey].(string); v != "" {
+		q.Set("sni", v)
+	}
+	if alpn := anyStrings(e["alpn"]); len(alpn) != 0 {
+		q.Set("alpn", strings.Join(lo.Map(alpn, func(item any, _ int) string { return fmt.Sprint(item) }), ","))
+	}
+	if v, _ := e["client-fingerprint"].(string); v != "" {
+		q.Set("fp", v)
+	}
+	if e["skip-cert-verify"] == true {
+		q.Set("allowInsecure", "1")
+	}
+	if r, ok := e["reality-opts"].(map[string]any); ok {
+		q.Set("security", "reality")
+		q.Set("pbk", fmt.Sprint(r["public-key"]))
+		if v, _ := r["short-id"].(string); v != "" {
+			q.Set("sid", v)
+		}
+	}
+}
+
+// linkTransport 传输层参数，使用 v2rayN 的 type、host、path 和 serviceName
+func linkTransport(e map[string]any, q url.Values) {
+	opts := func(k string) map[string]any {
+		m, _ := e[k].(map[string]any)
+		return m
+	}
+	q.Set("type", "tcp")
+	switch e["network"] {
+	case "ws":
+		o := opts("ws-opts")
+		q.Set("type", "ws")
+		if o["v2ray-http-upgrade"] == true {
+			q.Set("type", "httpupgrade")
+		}
+		if headers, ok := o["headers"].(map[string]any); ok && headers["Host"] != nil {
+			q.Set("host", fmt.Sprint(headers["Host"]))
+		}
+		if v, _ := o["path"].(string); v != "" {
+			q.Set("path", v)
+		}
+	case "grpc":
+		q.Set("type", "grpc")
+		q.Set("serviceName", fmt.Sprint(opts("grpc-opts")["grpc-service-name"]))
+	case "h2":
+		o := opts("h2-opts")
+		q.Set("type", "http")
+		if host := anyStrings(o["host"]); len(host) != 0 {
+			q.Set("host", strings.Join(lo.Map(host, func(item any, _ int) string { return fmt.Sprint(item) }), ","))
+		}
+		if v, _ := o["path"].(string); v != "" {
+			q.Set("path", v)
+		}
+	case "http":
+		o := opts("http-opts")
+		q.Set("headerType", "http")
+		if headers, ok := o["headers"].(map[string]any); ok {
+			if host := anyStrings(headers["Host"]); len(host) != 0 {
+				q.Set("host", fmt.Sprint(host[0]))
+			}
+		}
+		if path := anyStrings(o["path"]); len(path) != 0 {
+			q.Set("path", fmt.Sprint(path[0]))
+		}
+	}
+}
+
+// ssPlugin SIP002 中 plugin 参数的格式，shadow-tls 没有通用的链接格式
+func ssPlugin(plugin string, opts map[string]any) (string, error) {
+	s := func(k string) string {
+		v, _ := opts[k].(string)
+		return v
+	}
+	switch plugin {
+	case "obfs":
+		l := []string{"obfs-local", "obfs=" + s("mode")}
+		if v := s("host"); v != "" {
+			l = append(l, "obfs-host="+v)
+		}
+		return strings.Join(l, ";"), nil
+	case "v2ray-plugin":
+		l := []string{"v2ray-plugin", "mode=" + lo.CoalesceOrEmpty(s("mode"), "websocket")}
+		if opts["tls"] == true {
+			l = append(l, "tls")
+		}
+		if v := s("host"); v != "" {
+			l = append(l, "host="+v)
+		}
+		if v := s("path"); v != "" {
+			l = append(l, "path="+v)
+		}
+		return strings.Join(l, ";"), nil
+	}
+	return "", fmt.Errorf("ssPlugin: unsupported plugin %s", plugin)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestShareLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   clashProxy
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "ss",
+			proxy: clashProxy{Name: "ss 1", Type: "ss", Server: "1.1.1.1", Port: 8388, Extra: map[string]any{"cipher": "aes-128-gcm", "password": "pa:ss"}},
+			want:  "ss://YWVzLTEyOC1nY206cGE6c3M@1.1.1.1:8388#ss%201",
+		},
+		{
+			name:  "ss 2022",
+			proxy: clashProxy{Name: "ss", Type: "ss", Server: "::1", Port: 8388, Extra: map[string]any{"cipher": "2022-blake3-aes-128-gcm", "password": "a:b"}},
+			want:  "ss://2022-blake3-aes-128-gcm:a%3Ab@[::1]:8388#ss",
+		},
+		{
+			name: "ss plugin",
+			proxy: clashProxy{Name: "ss", Type: "ss", Server: "a.com", Port: 443, Extra: map[string]any{
+				"cipher": "aes-128-gcm", "password": "pa:ss", "plugin": "obfs",
+				"plugin-opts": map[string]any{"mode": "tls", "host": "b.com"},
+			}},
+			want: "ss://YWVzLTEyOC1nY206cGE6c3M@a.com:443?plugin=obfs-local%3Bobfs%3Dtls%3Bobfs-host%3Db.com#ss",
+		},
+		{
+			name: "ss unsupported plugin",
+			proxy: clashProxy{Name: "ss", Type: "ss", Server: "a.com", Port: 443, Extra: map[string]any{
+				"cipher": "aes-128-gcm", "password": "p", "plugin": "shadow-tls",
+			}},
+			wantErr: true,
+		},
+		{
+			name: "vmess ws tls",
+			proxy: clashProxy{Name: "vmess", Type: "vmess", Server: "a.com", Port: 443, Extra: map[string]any{
+				"uuid": "id", "alterId": 0, "cipher": "auto", "tls": true, "servername": "b.com",
+				"network": "ws", "ws-opts": map[string]any{"path": "/ws", "headers": map[string]any{"Host": "c.com"}},
+			}},
+			want: `{"v":"2","ps":"vmess","add":"a.com","port":443,"id":"id","aid":0,"scy":"auto","net":"ws","type":"none",
+				"host":"c.com","path":"/ws","tls":"tls","sni":"b.com","alpn":"","fp":""}`,
+		},
+		{
+			name: "vmess grpc",
+			proxy: clashProxy{Name: "vmess", Type: "vmess", Server: "a.com", Port: 443, Extra: map[string]any{
+				"uuid": "id", "network": "grpc", "grpc-opts": map[string]any{"grpc-service-name": "svc"},
+			}},
+			want: `{"v":"2","ps":"vmess","add":"a.com","port":443,"id":"id","aid":0,"scy":"auto","net":"grpc","type":"none",
+				"host":"","path":"svc"}`,
+		},
+		{
+			name: "vless reality",
+			proxy: clashProxy{Name: "vless", Type: "vless", Server: "a.com", Port: 443, Extra: map[string]any{
+				"uuid": "id", "flow": "xtls-rprx-vision", "tls": true, "servername": "b.com", "client-fingerprint": "chrome",
+				"reality-opts": map[string]any{"public-key": "pbk", "short-id": "01"},
+			}},
+			want: "vless://id@a.com:443?encryption=none&flow=xtls-rprx-vision&fp=chrome&pbk=pbk&security=reality&sid=01&sni=b.com&type=tcp#vless",
+		},
+		{
+			name:  "vless without tls",
+			proxy: clashProxy{Name: "vless", Type: "vless", Server: "a.com", Port: 80, Extra: map[string]any{"uuid": "id"}},
+			want:  "vless://id@a.com:80?encryption=none&security=none&type=tcp#vless",
+		},
+		{
+			name: "trojan",
+			proxy: clashProxy{Name: "trojan", Type: "trojan", Server: "a.com", Port: 443, Extra: map[string]any{
+				"password": "p@ss", "sni": "b.com", "skip-cert-verify": true, "alpn": []any{"h2", "http/1.1"},
+			}},
+			want: "trojan://p%40ss@a.com:443?allowInsecure=1&alpn=h2%2Chttp%2F1.1&security=tls&sni=b.com&type=tcp#trojan",
+		},
+		{
+			name: "hysteria2",
+			proxy: clashProxy{Name: "hy2", Type: "hysteria2", Server: "a.com", Port: 443, Extra: map[string]any{
+				"password": "p", "sni": "b.com", "obfs": "salamander", "obfs-password": "o", "ports": "20000-30000",
+			}},
+			want: "hysteria2://p@a.com:443,20000-30000?obfs=salamander&obfs-password=o&sni=b.com#hy2",
+		},
+		{
+			name:  "hysteria2 only ports",
+			proxy: clashProxy{Name: "hy2", Type: "hysteria2", Server: "a.com", Extra: map[string]any{"password": "p", "ports": "443,8443"}},
+			want:  "hysteria2://p@a.com:443,8443#hy2",
+		},
+		{
+			name:    "dialer-proxy",
+			proxy:   clashProxy{Name: "a", Type: "trojan", Server: "a.com", Port: 443, Extra: map[string]any{"dialer-proxy": "b"}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			proxy:   clashProxy{Name: "a", Type: "tuic", Server: "a.com", Port: 443, Extra: map[string]any{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := shareLink(tt.proxy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want error", link)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// vmess 为 base64 编码的 json，按 json 比较
+			if s, ok := strings.CutPrefix(link, "vmess://"); ok {
+				b, err := base64.StdEncoding.DecodeString(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				jsonEqual(t, jsonMap(t, string(b)), tt.want)
+				return
+			}
+			if link != tt.want {
+				t.Errorf("got  %s\nwant %s", link, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	config := jsonMap(t, `{"outbounds":[
+		{"type":"selector","tag":"select","outbounds":["HK 1","US 1"]},
+		{"type":"shadowsocks","tag":"HK 1","server":"1.1.1.1","server_port":8388,"method":"aes-128-gcm","password":"pa:ss"},
+		{"type":"trojan","tag":"US 1","server":"a.com","server_port":443,"password":"p","tls":{"enabled":true}},
+		{"type":"tuic","tag":"t1","server":"a.com","server_port":443},
+		{"type":"tuic","tag":"t2","server":"a.com","server_port":443},
+		{"type":"tuic","tag":"t3","server":"a.com","server_port":443},
+		{"type":"tuic","tag":"t4","server":"a.com","server_port":443},
+		{"type":"trojan","tag":"chain","server":"a.com","server_port":443,"password":"p","detour":"HK 1"}
+	]}`)
+	nodes := []TagWithVisible{{Tag: "select"}, {Tag: "HK 1"}, {Tag: "US 1"}, {Tag: "t1"}, {Tag: "t2"}, {Tag: "t3"}, {Tag: "t4"}, {Tag: "chain", Visible: []string{"g"}}}
+
+	body, warnings, err := shareLinks(config, nodes, "", "US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ss://YWVzLTEyOC1nY206cGE6c3M@1.1.1.1:8388#HK%201"
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+	wantWarning := "links: 4 nodes can not be expressed as links: t1, t2, t3, ..."
+	if len(warnings) != 1 || warnings[0] != wantWarning {
+		t.Errorf("warnings = %q, want %q", warnings, wantWarning)
+	}
+}
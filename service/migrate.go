@@ -0,0 +1,435 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/jsonc"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model"
+)
+
+// MigrateTemplate 把 1.10 时期的旧模板升级为 ver 版本的格式，返回升级后的模板和修改记录
+func MigrateTemplate(b []byte, ver model.SingBoxVer) (map[string]any, []string, error) {
+	m := map[string]any{}
+	err := json.Unmarshal(jsonc.ToJSON(b), &m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("MigrateTemplate: %w: %w", ErrTemplate, err)
+	}
+	return m, upgradeTemplate(m, ver), nil
+}
+
+// migrateTemplate 在 convert2sing 之前升级模板，没有需要修改的内容时返回原模板
+func migrateTemplate(b []byte, ver model.SingBoxVer) ([]byte, []string, error) {
+	if ver < model.SING111 {
+		return b, nil, nil
+	}
+	m, changes, err := MigrateTemplate(b, ver)
+	if err != nil {
+		return nil, nil, fmt.Errorf("migrateTemplate: %w", err)
+	}
+	if len(changes) == 0 {
+		return b, nil, nil
+	}
+	nb, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("migrateTemplate: %w", err)
+	}
+	return nb, changes, nil
+}
+
+func upgradeTemplate(config map[string]any, ver model.SingBoxVer) []string {
+	changes := []string{}
+	if ver < model.SING111 {
+		return changes
+	}
+	changes = append(changes, upgradeInbounds(config)...)
+	changes = append(changes, upgradeSpecialOutbounds(config)...)
+	if ver < model.SING112 {
+		return changes
+	}
+	changes = append(changes, upgradeDNSServers(config)...)
+	changes = append(changes, upgradeDNSOutboundRules(config)...)
+	return changes
+}
+
+// upgradeInbounds inet4_address 等字段合并为 address，sniff 和 domain_strategy 改为路由规则动作
+func upgradeInbounds(config map[string]any) []string {
+	changes := []string{}
+	var sniff, resolve map[string]any
+	for i, v := range utils.AnyGet[[]any](config, "inbounds") {
+		in, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range slices.Sorted(maps.Keys(addressFields)) {
+			oldFields := addressFields[field]
+			var list []any
+			for _, f := range oldFields {
+				switch a := in[f].(type) {
+				case []any:
+					list = append(list, a...)
+				case string:
+					list = append(list, a)
+				default:
+					continue
+				}
+				delete(in, f)
+			}
+			if len(list) != 0 {
+				in[field] = append(utils.AnyGet[[]any](in, field), list...)
+				changes = append(changes, fmt.Sprintf("inbounds[%d]: %v 合并为 %v", i, strings.Join(oldFields[:], ", "), field))
+			}
+		}
+		if s, ok := in["sniff"].(bool); ok {
+			delete(in, "sniff")
+			if s && sniff == nil {
+				sniff = map[string]any{"action": "sniff"}
+				if t, ok := in["sniff_timeout"]; ok {
+					sniff["timeout"] = t
+				}
+			}
+			changes = append(changes, fmt.Sprintf("inbounds[%d]: sniff 改为 sniff 路由规则", i))
+		}
+		delete(in, "sniff_timeout")
+		if _, ok := in["sniff_override_destination"]; ok {
+			delete(in, "sniff_override_destination")
+			changes = append(changes, fmt.Sprintf("inbounds[%d]: sniff_override_destination 已不再支持，已删除", i))
+		}
+		if s, ok := in["domain_strategy"].(string); ok {
+			delete(in, "domain_strategy")
+			if resolve == nil {
+				resolve = map[string]any{"action": "resolve", "strategy": s}
+			}
+			changes = append(changes, fmt.Sprintf("inbounds[%d]: domain_strategy 改为 resolve 路由规则", i))
+		}
+	}
+	if sniff == nil && resolve == nil {
+		return changes
+	}
+
+	route := utils.AnyGet[map[string]any](config, "route")
+	if route == nil {
+		route = map[string]any{}
+		config["route"] = route
+	}
+	rules := utils.AnyGet[[]any](route, "rules")
+	head := []any{}
+	if sniff != nil {
+		head = append(head, sniff)
+	}
+	if resolve != nil {
+		head = append(head, resolve)
+	}
+	utils.AnySet(&route, append(head, rules...), "rules")
+	return changes
+}
+
+// upgradeSpecialOutbounds dns 和 block 类型的出站改为 hijack-dns 和 reject 规则动作
+func upgradeSpecialOutbounds(config map[string]any) []string {
+	changes := []string{}
+	special := map[string]string{}
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	newOut := make([]any, 0, len(outbounds))
+	for _, v := range outbounds {
+		t := utils.AnyGet[string](v, "type")
+		if t == "dns" || t == "block" {
+			tag := utils.AnyGet[string](v, "tag")
+			special[tag] = t
+			changes = append(changes, fmt.Sprintf("outbounds: 删除 %v 类型的出站 %v", t, tag))
+			continue
+		}
+		newOut = append(newOut, v)
+	}
+	// 模板中没有写出但被规则引用的也按惯例处理
+	for tag, t := range map[string]string{"dns-out": "dns", "block": "block"} {
+		if _, ok := special[tag]; !ok && !slices.ContainsFunc(newOut, func(v any) bool {
+			return utils.AnyGet[string](v, "tag") == tag
+		}) {
+			special[tag] = t
+		}
+	}
+	if len(special) == 0 {
+		return changes
+	}
+	if outbounds != nil {
+		utils.AnySet(&config, newOut, "outbounds")
+	}
+
+	route := utils.AnyGet[map[string]any](config, "route")
+	for i, v := range utils.AnyGet[[]any](route, "rules") {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		t, ok := special[utils.AnyGet[string](rule, "outbound")]
+		if !ok {
+			continue
+		}
+		delete(rule, "outbound")
+		if t == "dns" {
+			rule["action"] = "hijack-dns"
+		} else {
+			rule["action"] = "reject"
+		}
+		changes = append(changes, fmt.Sprintf("route.rules[%d]: 改为 %v 动作", i, rule["action"]))
+	}
+	if final := utils.AnyGet[string](route, "final"); final != "" {
+		if _, ok := special[final]; ok {
+			delete(route, "final")
+			changes = append(changes, "route.final: 不能指向 "+final+"，已删除")
+		}
+	}
+	return changes
+}
+
+var legacyRcode = map[string]string{
+	"success":         "NOERROR",
+	"format_error":    "FORMERR",
+	"server_failure":  "SERVFAIL",
+	"name_error":      "NXDOMAIN",
+	"not_implemented": "NOTIMP",
+	"refused":         "REFUSED",
+}
+
+// upgradeDNSServers address 格式的 dns 服务器改为 1.12 的 type/server 格式
+func upgradeDNSServers(config map[string]any) []string {
+	changes := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	servers := utils.AnyGet[[]any](dns, "servers")
+	if len(servers) == 0 {
+		return changes
+	}
+	fakeip := utils.AnyGet[map[string]any](dns, "fakeip")
+	rcodeServer := map[string]string{}
+	serverOptions := map[string]map[string]any{}
+	newServers := make([]any, 0, len(servers))
+
+	for i, v := range servers {
+		server, ok := v.(map[string]any)
+		if !ok {
+			newServers = append(newServers, v)
+			continue
+		}
+		address := utils.AnyGet[string](server, "address")
+		if address == "" {
+			newServers = append(newServers, server)
+			continue
+		}
+		tag := utils.AnyGet[string](server, "tag")
+		if after, ok := strings.CutPrefix(address, "rcode://"); ok {
+			rcodeServer[tag] = legacyRcode[after]
+			changes = append(changes, fmt.Sprintf("dns.servers[%d]: 删除 rcode 服务器 %v，改为 predefined 规则", i, tag))
+			continue
+		}
+		ns, err := typedDNSServer(address)
+		if err != nil {
+			newServers = append(newServers, server)
+			changes = append(changes, fmt.Sprintf("dns.servers[%d]: 无法识别的地址 %v，未修改", i, address))
+			continue
+		}
+		if ns["type"] == "fakeip" {
+			for _, k := range []string{"inet4_range", "inet6_range"} {
+				if r, ok := fakeip[k]; ok {
+					ns[k] = r
+				}
+			}
+		}
+		ns["tag"] = tag
+		if v, ok := server["detour"]; ok {
+			ns["detour"] = v
+		}
+		// 1.12 不允许 detour 到 direct
+		if utils.AnyGet[string](ns, "detour") == "direct" {
+			delete(ns, "detour")
+		}
+		// 1.12 的服务器不再有 strategy 和 client_subnet，之后移动到使用它的规则上
+		for _, k := range []string{"strategy", "client_subnet"} {
+			if v, ok := server[k]; ok {
+				if serverOptions[tag] == nil {
+					serverOptions[tag] = map[string]any{}
+				}
+				serverOptions[tag][k] = v
+			}
+		}
+		if r := utils.AnyGet[string](server, "address_resolver"); r != "" {
+			if s := utils.AnyGet[string](server, "address_strategy"); s != "" {
+				ns["domain_resolver"] = map[string]any{"server": r, "strategy": s}
+			} else {
+				ns["domain_resolver"] = r
+			}
+		}
+		newServers = append(newServers, ns)
+		changes = append(changes, fmt.Sprintf("dns.servers[%d]: %v 改为 %v 类型", i, address, ns["type"]))
+	}
+	if fakeip != nil {
+		delete(dns, "fakeip")
+		changes = append(changes, "dns.fakeip: 移动到 fakeip 类型的服务器")
+	}
+	utils.AnySet(&dns, newServers, "servers")
+
+	for i, v := range utils.AnyGet[[]any](dns, "rules") {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		rcode, ok := rcodeServer[utils.AnyGet[string](rule, "server")]
+		if !ok {
+			continue
+		}
+		delete(rule, "server")
+		rule["action"] = "predefined"
+		if rcode != "" && rcode != "NOERROR" {
+			rule["rcode"] = rcode
+		}
+		changes = append(changes, fmt.Sprintf("dns.rules[%d]: 改为 predefined 动作", i))
+	}
+	changes = append(changes, moveDNSServerOptions(dns, serverOptions)...)
+	return changes
+}
+
+// moveDNSServerOptions 服务器上的 strategy 和 client_subnet 移动到使用该服务器的 dns 规则，
+// 默认服务器的移动到 dns 下的同名字段，都没有使用的服务器删除这些字段
+func moveDNSServerOptions(dns map[string]any, serverOptions map[string]map[string]any) []string {
+	changes := []string{}
+	if len(serverOptions) == 0 {
+		return changes
+	}
+	moved := map[string]bool{}
+	for i, v := range utils.AnyGet[[]any](dns, "rules") {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if a := utils.AnyGet[string](rule, "action"); a != "" && a != "route" {
+			continue
+		}
+		tag := utils.AnyGet[string](rule, "server")
+		opts, ok := serverOptions[tag]
+		if !ok {
+			continue
+		}
+		for _, k := range slices.Sorted(maps.Keys(opts)) {
+			if _, ok := rule[k]; !ok {
+				rule[k] = opts[k]
+			}
+		}
+		moved[tag] = true
+		changes = append(changes, fmt.Sprintf("dns.rules[%d]: 服务器 %v 的 %v 移动到规则", i, tag, strings.Join(slices.Sorted(maps.Keys(opts)), ", ")))
+	}
+
+	// 没有 final 时第一个服务器为默认服务器
+	final := utils.AnyGet[string](dns, "final")
+	if final == "" {
+		if servers := utils.AnyGet[[]any](dns, "servers"); len(servers) != 0 {
+			final = utils.AnyGet[string](servers[0], "tag")
+		}
+	}
+	if opts, ok := serverOptions[final]; ok {
+		for _, k := range slices.Sorted(maps.Keys(opts)) {
+			if _, ok := dns[k]; ok {
+				continue
+			}
+			dns[k] = opts[k]
+			changes = append(changes, fmt.Sprintf("dns: 默认服务器 %v 的 %v 移动到 dns.%v", final, k, k))
+		}
+		moved[final] = true
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(serverOptions)) {
+		if !moved[tag] {
+			changes = append(changes, fmt.Sprintf("dns.servers: 服务器 %v 没有被规则使用，%v 无法移动，已删除", tag, strings.Join(slices.Sorted(maps.Keys(serverOptions[tag])), ", ")))
+		}
+	}
+	return changes
+}
+
+func typedDNSServer(address string) (map[string]any, error) {
+	switch address {
+	case "local":
+		return map[string]any{"type": "local"}, nil
+	case "fakeip":
+		return map[string]any{"type": "fakeip"}, nil
+	}
+	if !strings.Contains(address, "://") {
+		address = "udp://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("typedDNSServer: %w", err)
+	}
+	ns := map[string]any{}
+	switch u.Scheme {
+	case "udp", "tcp", "tls", "quic", "https", "h3":
+		ns["type"] = u.Scheme
+	case "dhcp":
+		ns["type"] = "dhcp"
+		if u.Host != "" && u.Host != "auto" {
+			ns["interface"] = u.Host
+		}
+		return ns, nil
+	default:
+		return nil, fmt.Errorf("typedDNSServer: unsupported scheme %v", u.Scheme)
+	}
+	host := u.Host
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host = h
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("typedDNSServer: %w", err)
+		}
+		ns["server_port"] = port
+	}
+	ns["server"] = strings.Trim(host, "[]")
+	if (u.Scheme == "https" || u.Scheme == "h3") && u.Path != "" && u.Path != "/dns-query" {
+		ns["path"] = u.Path
+	}
+	return ns, nil
+}
+
+// upgradeDNSOutboundRules outbound 为 any 的 dns 规则改为 route.default_domain_resolver
+func upgradeDNSOutboundRules(config map[string]any) []string {
+	changes := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	rules := utils.AnyGet[[]any](dns, "rules")
+	if len(rules) == 0 {
+		return changes
+	}
+	route := utils.AnyGet[map[string]any](config, "route")
+	newRules := make([]any, 0, len(rules))
+	for i, v := range rules {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			newRules = append(newRules, v)
+			continue
+		}
+		out := utils.AnyGet[[]any](rule, "outbound")
+		server := utils.AnyGet[string](rule, "server")
+		if hasExtraField(rule, "outbound", "server", "strategy", "client_subnet") || len(out) != 1 || out[0] != "any" || server == "" {
+			newRules = append(newRules, v)
+			continue
+		}
+		if route == nil {
+			route = map[string]any{}
+			config["route"] = route
+		}
+		if _, ok := route["default_domain_resolver"]; !ok {
+			resolver := map[string]any{"server": server}
+			for _, k := range []string{"strategy", "client_subnet"} {
+				if v, ok := rule[k]; ok {
+					resolver[k] = v
+				}
+			}
+			route["default_domain_resolver"] = resolver
+		}
+		changes = append(changes, fmt.Sprintf("dns.rules[%d]: outbound any 改为 route.default_domain_resolver", i))
+	}
+	utils.AnySet(&dns, newRules, "rules")
+	return changes
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xmdhs/clash2singbox/model"
+)
+
+func TestMigrateTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ver     model.SingBoxVer
+		config  string
+		want    string
+		changes int
+	}{
+		{
+			name:   "1.10 target unchanged",
+			ver:    model.SING110,
+			config: `{"inbounds":[{"type":"tun","inet4_address":"172.19.0.1/30","sniff":true}]}`,
+			want:   `{"inbounds":[{"type":"tun","inet4_address":"172.19.0.1/30","sniff":true}]}`,
+		},
+		{
+			name: "inbounds and special outbounds for 1.11",
+			ver:  model.SING111,
+			config: `{"inbounds":[{"type":"tun","inet4_address":"172.19.0.1/30","inet6_address":["fdfe:dcba:9876::1/126"],"sniff":true,"sniff_override_destination":true,"domain_strategy":"ipv4_only"}],
+				"outbounds":[{"type":"direct","tag":"direct"},{"type":"dns","tag":"dns-out"},{"type":"block","tag":"block"}],
+				"route":{"rules":[{"protocol":"dns","outbound":"dns-out"},{"domain":["ad.com"],"outbound":"block"}],"final":"block"}}`,
+			want: `{"inbounds":[{"type":"tun","address":["172.19.0.1/30","fdfe:dcba:9876::1/126"]}],
+				"outbounds":[{"type":"direct","tag":"direct"}],
+				"route":{"rules":[
+					{"action":"sniff"},
+					{"action":"resolve","strategy":"ipv4_only"},
+					{"protocol":"dns","action":"hijack-dns"},
+					{"domain":["ad.com"],"action":"reject"}
+				]}}`,
+			changes: 9,
+		},
+		{
+			name: "dns servers for 1.12",
+			ver:  model.SING112,
+			config: `{"dns":{"servers":[
+					{"tag":"remote","address":"https://1.1.1.1/dns-query","detour":"proxy","client_subnet":"1.2.3.0/24"},
+					{"tag":"local","address":"223.5.5.5","detour":"direct","strategy":"ipv4_only"},
+					{"tag":"doh","address":"h3://dns.google/custom","address_resolver":"local","address_strategy":"ipv4_only"},
+					{"tag":"fakeip","address":"fakeip"},
+					{"tag":"block","address":"rcode://refused"}
+				],
+				"fakeip":{"enabled":true,"inet4_range":"198.18.0.0/15"},
+				"rules":[
+					{"outbound":["any"],"server":"local"},
+					{"domain":["ad.com"],"server":"block"},
+					{"domain":["a.com"],"server":"doh"}
+				]}}`,
+			want: `{"dns":{"servers":[
+					{"type":"https","tag":"remote","server":"1.1.1.1","detour":"proxy"},
+					{"type":"udp","tag":"local","server":"223.5.5.5"},
+					{"type":"h3","tag":"doh","server":"dns.google","path":"/custom","domain_resolver":{"server":"local","strategy":"ipv4_only"}},
+					{"type":"fakeip","tag":"fakeip","inet4_range":"198.18.0.0/15"}
+				],
+				"client_subnet":"1.2.3.0/24",
+				"rules":[
+					{"domain":["ad.com"],"action":"predefined","rcode":"REFUSED"},
+					{"domain":["a.com"],"server":"doh"}
+				]},
+				"route":{"default_domain_resolver":{"server":"local","strategy":"ipv4_only"}}}`,
+			changes: 10,
+		},
+		{
+			name: "server options without a rule are dropped",
+			ver:  model.SING112,
+			config: `{"dns":{"servers":[
+					{"tag":"a","address":"1.1.1.1"},
+					{"tag":"b","address":"8.8.8.8","strategy":"ipv6_only"}
+				]}}`,
+			want: `{"dns":{"servers":[
+					{"type":"udp","tag":"a","server":"1.1.1.1"},
+					{"type":"udp","tag":"b","server":"8.8.8.8"}
+				]}}`,
+			changes: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, changes, err := MigrateTemplate([]byte(tt.config), tt.ver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, m, tt.want)
+			if len(changes) != tt.changes {
+				t.Errorf("changes = %q, want %d", changes, tt.changes)
+			}
+		})
+	}
+}
+
+// TestMigrateRoundTrip 旧模板升级到 1.12 后再降级回 1.10，应与原模板相同
+func TestMigrateRoundTrip(t *testing.T) {
+	tests := []string{
+		`{"dns":{"servers":[
+				{"tag":"remote","address":"tls://8.8.8.8","detour":"proxy"},
+				{"tag":"local","address":"local"},
+				{"tag":"rcode-success","address":"rcode://success"}
+			],"rules":[{"domain":["ad.com"],"server":"rcode-success"},{"domain":["a.com"],"server":"local"}]},
+			"outbounds":[{"type":"direct","tag":"direct"},{"type":"dns","tag":"dns-out"},{"type":"block","tag":"block"}],
+			"route":{"rules":[{"protocol":"dns","outbound":"dns-out"},{"domain":["ad.com"],"outbound":"block"},{"domain":["a.com"],"outbound":"direct"}]}}`,
+		`{"inbounds":[{"type":"tun","inet4_address":["172.19.0.1/30"],"inet6_address":["fdfe:dcba:9876::1/126"]}],
+			"outbounds":[{"type":"direct","tag":"direct"}],
+			"route":{"rules":[{"ip_is_private":true,"outbound":"direct"}]}}`,
+	}
+	for _, config := range tests {
+		up, _, err := MigrateTemplate([]byte(config), model.SING112)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := mustJSON(t, up)
+
+		// 已经是新格式的模板再次升级不应有变化
+		again, changes, err := MigrateTemplate(b, model.SING112)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("second migration changed %q", changes)
+		}
+		jsonEqual(t, again, string(b))
+
+		warnings := downgradeTemplate(up, model.SING110)
+		if len(warnings) != 0 {
+			t.Errorf("warnings = %q", warnings)
+		}
+		jsonEqual(t, up, config)
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
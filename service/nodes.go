@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/jsonc"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+// Nodes 只拉取订阅并转换节点，不套用模板，返回节点信息和可直接使用的 outbounds。
+// 订阅的合并、过滤、去重和重命名与 MakeConfig 相同，模板只用于避开其中已有的 tag
+func (c *Convert) Nodes(cxt context.Context, arg model.ConvertArg, configByte []byte) ([]model.Node, []any, error) {
+	tplTag, err := nodesReserved(arg, configByte)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Nodes: %w", err)
+	}
+	rn, err := newRenamer(arg.Rename, arg.Emoji)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Nodes: %w", err)
+	}
+	subs, err := fetchSubs(cxt, c.c, subscriptions(arg.Sub, arg.Subs), arg.AddTag, arg.Ver, c.l, tplTag, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Nodes: %w", err)
+	}
+	subs, _ = dedupNodes(subs, arg.Dedup)
+
+	s := []singbox.SingBoxOut{}
+	singList := []map[string]any{}
+	sURL := []string{}
+	singURL := []string{}
+	for _, v := range subs {
+		s = append(s, v.s...)
+		singList = append(singList, v.singList...)
+		sURL = append(sURL, lo.Times(len(v.s), func(int) string { return v.url })...)
+		singURL = append(singURL, lo.Times(len(v.singList), func(int) string { return v.url })...)
+	}
+	renameNodes(s, singList, nil, rn, tplTag)
+
+	nodes := make([]model.Node, 0, len(s)+len(singList))
+	outbounds := make([]any, 0, len(s)+len(singList))
+	for i, v := range s {
+		nodes = append(nodes, model.Node{
+			Tag:     v.Tag,
+			Type:    v.Type,
+			Server:  v.Server,
+			Port:    v.ServerPort,
+			Sub:     sURL[i],
+			Ignored: v.Ignored,
+		})
+		outbounds = append(outbounds, v)
+	}
+	for i, v := range singList {
+		nodes = append(nodes, model.Node{
+			Tag:    utils.AnyGet[string](v, "tag"),
+			Type:   utils.AnyGet[string](v, "type"),
+			Server: utils.AnyGet[string](v, "server"),
+			Port:   int(utils.AnyGet[float64](v, "server_port")),
+			Sub:    singURL[i],
+		})
+		outbounds = append(outbounds, v)
+	}
+	return nodes, outbounds, nil
+}
+
+// nodesReserved 请求中或默认模板中已有的 tag，远程模板不为了列出节点而下载，直接跳过
+func nodesReserved(arg model.ConvertArg, configByte []byte) ([]string, error) {
+	if arg.ConfigUrl != "" {
+		return nil, nil
+	}
+	if arg.Config == nil {
+		arg.Config = configByte
+	}
+	tpl, err := renderTemplate(arg.Config, arg)
+	if err != nil {
+		return nil, fmt.Errorf("nodesReserved: %w", err)
+	}
+	ext, err := getExtTag(jsonc.ToJSON(tpl))
+	if err != nil {
+		return nil, fmt.Errorf("nodesReserved: %w: %w", ErrTemplate, err)
+	}
+	return lo.Map(ext, func(item extTag, _ int) string {
+		return item.tag
+	}), nil
+}
+
+// splitSub 与 httputils.GetAny 相同的方式拆分多个订阅
+func splitSub(sub string) []string {
+	urls := strings.Split(sub, "|")
+	if len(urls) == 1 {
+		b, err := base64.StdEncoding.DecodeString(sub)
+		if err == nil {
+			urls = lo.FilterMap(bytes.Split(b, []byte{'\n'}), func(b []byte, _ int) (string, bool) {
+				s := string(b)
+				return s, s != ""
+			})
+		}
+	}
+	return urls
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+func TestNodesReserved(t *testing.T) {
+	const def = `{"outbounds":[{"type":"selector","tag":"select","outbounds":[]},{"type":"direct","tag":"direct"}]}`
+	tests := []struct {
+		name string
+		arg  model.ConvertArg
+		want []string
+	}{
+		{name: "default template", want: []string{"select"}},
+		{name: "inline template", arg: model.ConvertArg{Config: []byte(`{"outbounds":[{"type":"direct","tag":"d"}]}`)}, want: []string{"d"}},
+		{name: "remote template is not downloaded", arg: model.ConvertArg{ConfigUrl: "http://127.0.0.1:1/tpl.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nodesReserved(tt.arg, []byte(def))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/jsonc"
+)
+
+// applyOverlay 修改生成的配置，patch 为 json 对象时按 RFC 7396 merge patch 处理，
+// 为数组时按 RFC 6902 json patch 处理，支持 jsonc。任何一个操作失败都返回错误。
+func applyOverlay(config map[string]any, patch []byte) (map[string]any, error) {
+	var p any
+	err := json.Unmarshal(jsonc.ToJSON(patch), &p)
+	if err != nil {
+		return nil, fmt.Errorf("applyOverlay: %w: %w", ErrOverlay, err)
+	}
+	// outbounds 中有 singbox.SingBoxOut，统一为 map 后 json pointer 才能访问
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("applyOverlay: %w", err)
+	}
+	var doc any
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("applyOverlay: %w", err)
+	}
+
+	switch p := p.(type) {
+	case map[string]any:
+		doc = mergePatch(doc, p)
+	case []any:
+		for i, v := range p {
+			doc, err = jsonPatchOp(doc, v)
+			if err != nil {
+				return nil, fmt.Errorf("applyOverlay: %w: operation %d: %w", ErrOverlay, i, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("applyOverlay: %w: overlay must be an object or an array", ErrOverlay)
+	}
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("applyOverlay: %w: result is not an object", ErrOverlay)
+	}
+	return m, nil
+}
+
+// mergePatch RFC 7396，null 删除字段，对象递归合并，其他值直接替换
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+func jsonPatchOp(doc any, v any) (any, error) {
+	o, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("operation must be an object")
+	}
+	op, _ := o["op"].(string)
+	path, ok := o["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing path")
+	}
+	tokens, err := jsonPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	value, hasValue := o["value"]
+
+	switch op {
+	case "add", "replace", "test":
+		if !hasValue {
+			return nil, fmt.Errorf("%s: missing value", op)
+		}
+	case "move", "copy":
+		from, ok := o["from"].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: missing from", op)
+		}
+		fromTokens, err := jsonPointer(from)
+		if err != nil {
+			return nil, err
+		}
+		value, err = pointerGet(doc, fromTokens)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if op == "copy" {
+			value = deepCopy(value)
+			break
+		}
+		if path == from {
+			return doc, nil
+		}
+		if strings.HasPrefix(path, from+"/") {
+			return nil, fmt.Errorf("move: %s is a child of %s", path, from)
+		}
+		doc, err = pointerRemove(doc, fromTokens)
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown op %q", op)
+	}
+
+	switch op {
+	case "add", "move", "copy":
+		doc, err = pointerAdd(doc, tokens, value)
+	case "replace":
+		doc, err = pointerReplace(doc, tokens, value)
+	case "remove":
+		doc, err = pointerRemove(doc, tokens)
+	case "test":
+		var cur any
+		cur, err = pointerGet(doc, tokens)
+		if err == nil && !reflect.DeepEqual(cur, value) {
+			err = fmt.Errorf("test: %s does not match", path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// jsonPointer RFC 6901，"" 为整个文档
+func jsonPointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, v := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(v, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > n || (!allowEnd && i == n) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// pointerAt 找到 tokens 的父节点后调用 f，返回修改后的文档，数组插入和删除会替换父节点中的切片
+func pointerAt(doc any, tokens []string, f func(parent any, key string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return f(doc, tokens[0])
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		child, ok := c[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", tokens[0])
+		}
+		v, err := pointerAt(child, tokens[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		c[tokens[0]] = v
+		return c, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerAt(c[i], tokens[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = v
+		return c, nil
+	}
+	return nil, fmt.Errorf("%s: not an object or array", tokens[0])
+}
+
+func pointerGet(doc any, tokens []string) (any, error) {
+	for _, t := range tokens {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", t)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("%s: not an object or array", t)
+		}
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerAt(doc, tokens, func(parent any, key string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			c[key] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(key, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], append([]any{value}, c[i:]...)...), nil
+		}
+		return nil, fmt.Errorf("%s: parent is not an object or array", key)
+	})
+}
+
+func pointerReplace(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerAt(doc, tokens, func(parent any, key string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			if _, ok := c[key]; !ok {
+				return nil, fmt.Errorf("%s not found", key)
+			}
+			c[key] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(key, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("%s: parent is not an object or array", key)
+	})
+}
+
+func pointerRemove(doc any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("can not remove the whole document")
+	}
+	return pointerAt(doc, tokens, func(parent any, key string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			if _, ok := c[key]; !ok {
+				return nil, fmt.Errorf("%s not found", key)
+			}
+			delete(c, key)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(key, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%s: parent is not an object or array", key)
+	})
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, val := range v {
+			m[k] = deepCopy(val)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, val := range v {
+			l[i] = deepCopy(val)
+		}
+		return l
+	}
+	return v
+}
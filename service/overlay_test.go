@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyOverlay(t *testing.T) {
+	const config = `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"}]}`
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "merge patch null removes",
+			patch: `{"log":{"timestamp":null,"level":"warn"},"dns":null}`,
+			want:  `{"log":{"level":"warn"},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"}]}`,
+		},
+		{
+			name:  "merge patch null on missing key",
+			patch: `{"ntp":null,"log":{"output":null}}`,
+			want:  config,
+		},
+		{
+			name:  "merge patch replaces arrays and adds objects",
+			patch: `{"outbounds":[{"tag":"d"}],"ntp":{"enabled":true}}`,
+			want:  `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"d"}],"ntp":{"enabled":true}}`,
+		},
+		{
+			name:  "merge patch jsonc",
+			patch: "{\n// 注释\n\"log\":{\"level\":\"debug\",},}",
+			want:  `{"log":{"level":"debug","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"}]}`,
+		},
+		{
+			name:  "add with - index",
+			patch: `[{"op":"add","path":"/outbounds/-","value":{"tag":"d"}}]`,
+			want:  `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"},{"tag":"d"}]}`,
+		},
+		{
+			name:  "add inserts before index",
+			patch: `[{"op":"add","path":"/outbounds/1","value":{"tag":"d"}}]`,
+			want:  `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"d"},{"tag":"b"},{"tag":"c"}]}`,
+		},
+		{
+			name:  "move within array",
+			patch: `[{"op":"move","from":"/outbounds/0","path":"/outbounds/-"}]`,
+			want:  `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"b"},{"tag":"c"},{"tag":"a"}]}`,
+		},
+		{
+			name:  "move between objects",
+			patch: `[{"op":"move","from":"/dns/final","path":"/log/final"}]`,
+			want:  `{"log":{"level":"info","timestamp":true,"final":"remote"},"dns":{},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"}]}`,
+		},
+		{
+			name:  "move to same path",
+			patch: `[{"op":"move","from":"/log","path":"/log"}]`,
+			want:  config,
+		},
+		{
+			name:    "move into own child",
+			patch:   `[{"op":"move","from":"/log","path":"/log/inner"}]`,
+			wantErr: true,
+		},
+		{
+			name: "copy is deep",
+			patch: `[{"op":"copy","from":"/outbounds/0","path":"/outbounds/-"},
+				{"op":"replace","path":"/outbounds/3/tag","value":"d"}]`,
+			want: `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"},{"tag":"d"}]}`,
+		},
+		{
+			name:  "remove and escaped pointer",
+			patch: `[{"op":"remove","path":"/outbounds/1"},{"op":"add","path":"/a~1b~0c","value":1}]`,
+			want:  `{"log":{"level":"info","timestamp":true},"dns":{"final":"remote"},"outbounds":[{"tag":"a"},{"tag":"c"}],"a/b~c":1}`,
+		},
+		{
+			name:  "test passes",
+			patch: `[{"op":"test","path":"/log/level","value":"info"},{"op":"remove","path":"/dns"}]`,
+			want:  `{"log":{"level":"info","timestamp":true},"outbounds":[{"tag":"a"},{"tag":"b"},{"tag":"c"}]}`,
+		},
+		{
+			name:    "test fails",
+			patch:   `[{"op":"test","path":"/log/level","value":"warn"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "- index is only valid for add",
+			patch:   `[{"op":"replace","path":"/outbounds/-","value":{}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "index out of range",
+			patch:   `[{"op":"add","path":"/outbounds/4","value":{}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "leading zero index",
+			patch:   `[{"op":"remove","path":"/outbounds/01"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "replace missing key",
+			patch:   `[{"op":"replace","path":"/ntp","value":{}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown op",
+			patch:   `[{"op":"merge","path":"/log"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "result must be an object",
+			patch:   `[{"op":"replace","path":"","value":[]}]`,
+			wantErr: true,
+		},
+		{
+			name:    "not an object or array",
+			patch:   `"log"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := applyOverlay(jsonMap(t, config), []byte(tt.patch))
+			if tt.wantErr {
+				if !errors.Is(err, ErrOverlay) {
+					t.Fatalf("err = %v, want ErrOverlay", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, m, tt.want)
+		})
+	}
+}
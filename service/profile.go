@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/store"
+)
+
+var ErrToken = errors.New("token 错误")
+
+type Profile struct {
+	s store.ProfileStore
+	// l 修改和删除需要先读取校验 token 再写入，加锁避免同时修改和删除时删除的 profile 被重新写入
+	l sync.Mutex
+}
+
+func NewProfile(s store.ProfileStore) *Profile {
+	return &Profile{s: s}
+}
+
+// Create 保存参数，返回 id 和用于修改删除的 token，token 只保存哈希
+func (p *Profile) Create(cxt context.Context, arg model.ConvertArg) (string, string, error) {
+	id := randString(9)
+	token := randString(24)
+	now := time.Now()
+	err := p.s.Put(cxt, model.Profile{
+		ID:        id,
+		TokenHash: hashToken(token),
+		Arg:       arg,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("Create: %w", err)
+	}
+	return id, token, nil
+}
+
+func (p *Profile) Get(cxt context.Context, id string) (model.ConvertArg, error) {
+	pr, err := p.s.Get(cxt, id)
+	if err != nil {
+		return model.ConvertArg{}, fmt.Errorf("Get: %w", err)
+	}
+	return pr.Arg, nil
+}
+
+func (p *Profile) Update(cxt context.Context, id, token string, arg model.ConvertArg) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	pr, err := p.auth(cxt, id, token)
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	pr.Arg = arg
+	pr.UpdatedAt = time.Now()
+	err = p.s.Put(cxt, pr)
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	return nil
+}
+
+func (p *Profile) Delete(cxt context.Context, id, token string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	_, err := p.auth(cxt, id, token)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	err = p.s.Delete(cxt, id)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	return nil
+}
+
+func (p *Profile) auth(cxt context.Context, id, token string) (model.Profile, error) {
+	pr, err := p.s.Get(cxt, id)
+	if err != nil {
+		return pr, fmt.Errorf("auth: %w", err)
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(pr.TokenHash)) != 1 {
+		return pr, fmt.Errorf("auth: %w", ErrToken)
+	}
+	return pr, nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func randString(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
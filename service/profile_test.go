@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/store"
+)
+
+func TestProfile(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProfile(s)
+	ctx := context.Background()
+
+	id, token, err := p.Create(ctx, model.ConvertArg{Sub: "https://a.com/sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.Get(ctx, id)
+	if err != nil || a.Sub != "https://a.com/sub" {
+		t.Fatalf("got %+v %v", a, err)
+	}
+	pr, err := s.Get(ctx, id)
+	if err != nil || pr.TokenHash == token {
+		t.Fatalf("token is not hashed: %+v %v", pr, err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		token   string
+		wantErr error
+	}{
+		{name: "empty token", id: id, wantErr: ErrToken},
+		{name: "wrong token", id: id, token: token + "x", wantErr: ErrToken},
+		{name: "missing", id: "missing", token: token, wantErr: store.ErrNotFound},
+		{name: "invalid id", id: "../a", token: token, wantErr: store.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Update(ctx, tt.id, tt.token, model.ConvertArg{Sub: "https://b.com/sub"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Update: err = %v, want %v", err, tt.wantErr)
+			}
+			err = p.Delete(ctx, tt.id, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	err = p.Update(ctx, id, token, model.ConvertArg{Sub: "https://b.com/sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := p.Get(ctx, id); a.Sub != "https://b.com/sub" {
+		t.Errorf("sub = %q after update", a.Sub)
+	}
+	err = p.Delete(ctx, id, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Get(ctx, id)
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get after delete: err = %v", err)
+	}
+}
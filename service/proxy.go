@@ -0,0 +1,409 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/utils"
+)
+
+// clashProxy clash.meta 的节点，常用字段在前，其余字段放在 Extra
+type clashProxy struct {
+	Name   string         `yaml:"name"`
+	Type   string         `yaml:"type"`
+	Server string         `yaml:"server"`
+	Port   int            `yaml:"port"`
+	Extra  map[string]any `yaml:",inline"`
+}
+
+func (p *clashProxy) set(k string, v any) {
+	switch v := v.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+	case int:
+		if v == 0 {
+			return
+		}
+	case bool:
+		if !v {
+			return
+		}
+	case []any:
+		if len(v) == 0 {
+			return
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return
+		}
+	}
+	p.Extra[k] = v
+}
+
+// outboundMap outbounds 中有 singbox.SingBoxOut 和 []string 等类型，通过 json 统一为 map 和 []any
+func outboundMap(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("outboundMap: %w", err)
+	}
+	m := map[string]any{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("outboundMap: %w", err)
+	}
+	return m, nil
+}
+
+func anyInt(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// anyString 字段可以是字符串或字符串列表时取第一个
+func anyString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		if len(v) != 0 {
+			s, _ := v[0].(string)
+			return s
+		}
+	case []string:
+		if len(v) != 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func anyStrings(v any) []any {
+	switch v := v.(type) {
+	case string:
+		return []any{v}
+	case []any:
+		return v
+	case []string:
+		return lo.ToAnySlice(v)
+	}
+	return nil
+}
+
+// toClashProxy 把 sing-box 的出站转换为 clash.meta 的节点，outs 用于查找 shadowtls 等 detour 引用的出站
+func toClashProxy(out map[string]any, outs map[string]map[string]any) (clashProxy, error) {
+	p := clashProxy{
+		Name:   utils.AnyGet[string](out, "tag"),
+		Server: utils.AnyGet[string](out, "server"),
+		Port:   anyInt(out["server_port"]),
+		Extra:  map[string]any{},
+	}
+	tls := utils.AnyGet[map[string]any](out, "tls")
+	detour := utils.AnyGet[string](out, "detour")
+	t := utils.AnyGet[string](out, "type")
+
+	switch t {
+	case "shadowsocks":
+		p.Type = "ss"
+		p.set("cipher", out["method"])
+		p.set("password", out["password"])
+		p.set("udp", true)
+		err := clashSSPlugin(&p, out)
+		if err != nil {
+			return clashProxy{}, fmt.Errorf("toClashProxy: %w", err)
+		}
+		if st, ok := outs[detour]; ok && utils.AnyGet[string](st, "type") == "shadowtls" {
+			stTLS := utils.AnyGet[map[string]any](st, "tls")
+			p.Server = utils.AnyGet[string](st, "server")
+			p.Port = anyInt(st["server_port"])
+			p.set("plugin", "shadow-tls")
+			opts := map[string]any{
+				"host":     utils.AnyGet[string](stTLS, "server_name"),
+				"password": utils.AnyGet[string](st, "password"),
+			}
+			if v := anyInt(st["version"]); v != 0 {
+				opts["version"] = v
+			}
+			p.set("plugin-opts", opts)
+			if utls := utils.AnyGet[map[string]any](stTLS, "utls"); utils.AnyGet[bool](utls, "enabled") {
+				p.set("client-fingerprint", utls["fingerprint"])
+			}
+			detour = ""
+		}
+		if uot := utils.AnyGet[map[string]any](out, "udp_over_tcp"); utils.AnyGet[bool](uot, "enabled") {
+			p.set("udp-over-tcp", true)
+			p.set("udp-over-tcp-version", anyInt(uot["version"]))
+		}
+	case "vmess":
+		p.Type = "vmess"
+		p.set("uuid", out["uuid"])
+		p.Extra["alterId"] = anyInt(out["alter_id"])
+		p.Extra["cipher"] = lo.CoalesceOrEmpty(utils.AnyGet[string](out, "security"), "auto")
+		p.set("packet-encoding", out["packet_encoding"])
+		p.set("udp", true)
+		clashTLS(&p, tls, "servername", true)
+		err := clashTransport(&p, utils.AnyGet[map[string]any](out, "transport"), tls != nil)
+		if err != nil {
+			return clashProxy{}, fmt.Errorf("toClashProxy: %w", err)
+		}
+	case "vless":
+		p.Type = "vless"
+		p.set("uuid", out["uuid"])
+		p.set("flow", out["flow"])
+		p.set("packet-encoding", out["packet_encoding"])
+		p.set("udp", true)
+		clashTLS(&p, tls, "servername", true)
+		err := clashTransport(&p, utils.AnyGet[map[string]any](out, "transport"), tls != nil)
+		if err != nil {
+			return clashProxy{}, fmt.Errorf("toClashProxy: %w", err)
+		}
+	case "trojan":
+		p.Type = "trojan"
+		p.set("password", out["password"])
+		p.set("udp", true)
+		clashTLS(&p, tls, "sni", false)
+		err := clashTransport(&p, utils.AnyGet[map[string]any](out, "transport"), true)
+		if err != nil {
+			return clashProxy{}, fmt.Errorf("toClashProxy: %w", err)
+		}
+	case "hysteria2":
+		p.Type = "hysteria2"
+		p.set("password", out["password"])
+		ports := lo.Map(anyStrings(out["server_ports"]), func(item any, _ int) string {
+			s, _ := item.(string)
+			return strings.ReplaceAll(s, ":", "-")
+		})
+		p.set("ports", strings.Join(ports, ","))
+		p.set("up", anyInt(out["up_mbps"]))
+		p.set("down", anyInt(out["down_mbps"]))
+		if obfs := utils.AnyGet[map[string]any](out, "obfs"); obfs != nil {
+			p.set("obfs", obfs["type"])
+			p.set("obfs-password", obfs["password"])
+		}
+		clashTLS(&p, tls, "sni", false)
+	case "hysteria":
+		p.Type = "hysteria"
+		p.set("auth-str", out["auth_str"])
+		p.set("up", anyInt(out["up_mbps"]))
+		p.set("down", anyInt(out["down_mbps"]))
+		p.set("obfs", out["obfs"])
+		p.set("recv-window-conn", anyInt(out["recv_window_conn"]))
+		p.set("recv-window", anyInt(out["recv_window"]))
+		p.set("disable_mtu_discovery", out["disable_mtu_discovery"])
+		clashTLS(&p, tls, "sni", false)
+	case "tuic":
+		p.Type = "tuic"
+		p.set("uuid", out["uuid"])
+		p.set("password", out["password"])
+		p.set("congestion-controller", out["congestion_control"])
+		p.set("udp-relay-mode", out["udp_relay_mode"])
+		p.set("reduce-rtt", out["zero_rtt_handshake"])
+		if d, err := time.ParseDuration(utils.AnyGet[string](out, "heartbeat")); err == nil {
+			p.set("heartbeat-interval", int(d.Milliseconds()))
+		}
+		clashTLS(&p, tls, "sni", false)
+	case "anytls":
+		p.Type = "anytls"
+		p.set("password", out["password"])
+		p.set("udp", true)
+		clashTLS(&p, tls, "sni", false)
+	case "socks":
+		p.Type = "socks5"
+		p.set("username", out["username"])
+		p.set("password", out["password"])
+		p.set("udp", true)
+	case "http":
+		p.Type = "http"
+		p.set("username", out["username"])
+		p.set("password", out["password"])
+		clashTLS(&p, tls, "sni", true)
+	case "wireguard":
+		p.Type = "wireguard"
+		p.set("private-key", out["private_key"])
+		peer := out
+		if peers := utils.AnyGet[[]any](out, "peers"); len(peers) != 0 {
+			peer, _ = peers[0].(map[string]any)
+			p.Server = utils.AnyGet[string](peer, "server")
+			p.Port = anyInt(peer["server_port"])
+			p.set("public-key", peer["public_key"])
+		} else {
+			p.set("public-key", out["peer_public_key"])
+		}
+		p.set("pre-shared-key", peer["pre_shared_key"])
+		p.set("reserved", peer["reserved"])
+		for _, v := range anyStrings(out["local_address"]) {
+			s, _ := v.(string)
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				continue
+			}
+			if prefix.Addr().Is4() {
+				p.set("ip", prefix.Addr().String())
+			} else {
+				p.set("ipv6", prefix.Addr().String())
+			}
+		}
+		p.set("mtu", anyInt(out["mtu"]))
+		p.set("udp", true)
+	default:
+		return clashProxy{}, fmt.Errorf("toClashProxy: unsupported type %s", t)
+	}
+
+	p.set("dialer-proxy", detour)
+	p.set("tfo", out["tcp_fast_open"])
+	if mux := utils.AnyGet[map[string]any](out, "multiplex"); utils.AnyGet[bool](mux, "enabled") {
+		smux := map[string]any{"enabled": true}
+		for k, v := range map[string]string{"protocol": "protocol", "max_connections": "max-connections", "min_streams": "min-streams", "max_streams": "max-streams", "padding": "padding"} {
+			if mv, ok := mux[k]; ok {
+				smux[v] = mv
+			}
+		}
+		p.set("smux", smux)
+	}
+	return p, nil
+}
+
+// clashTLS flag 为 true 时输出 tls: true，vmess 等协议的 tls 是可选的
+func clashTLS(p *clashProxy, tls map[string]any, sniKey string, flag bool) {
+	if !utils.AnyGet[bool](tls, "enabled") {
+		return
+	}
+	if flag {
+		p.set("tls", true)
+	}
+	p.set(sniKey, tls["server_name"])
+	p.set("skip-cert-verify", tls["insecure"])
+	p.set("alpn", anyStrings(tls["alpn"]))
+	if utls := utils.AnyGet[map[string]any](tls, "utls"); utils.AnyGet[bool](utls, "enabled") {
+		p.set("client-fingerprint", utls["fingerprint"])
+	}
+	if reality := utils.AnyGet[map[string]any](tls, "reality"); utils.AnyGet[bool](reality, "enabled") {
+		opts := map[string]any{"public-key": reality["public_key"]}
+		if v := utils.AnyGet[string](reality, "short_id"); v != "" {
+			opts["short-id"] = v
+		}
+		p.set("reality-opts", opts)
+	}
+}
+
+func clashTransport(p *clashProxy, t map[string]any, tls bool) error {
+	if t == nil {
+		return nil
+	}
+	headers := map[string]any{}
+	for k, v := range utils.AnyGet[map[string]any](t, "headers") {
+		headers[k] = anyString(v)
+	}
+	switch tt := utils.AnyGet[string](t, "type"); tt {
+	case "ws", "httpupgrade":
+		p.set("network", "ws")
+		opts := map[string]any{}
+		if v := utils.AnyGet[string](t, "path"); v != "" {
+			opts["path"] = v
+		}
+		if host := anyString(t["host"]); host != "" {
+			headers["Host"] = host
+		}
+		if len(headers) != 0 {
+			opts["headers"] = headers
+		}
+		if v := anyInt(t["max_early_data"]); v != 0 {
+			opts["max-early-data"] = v
+		}
+		if v := utils.AnyGet[string](t, "early_data_header_name"); v != "" {
+			opts["early-data-header-name"] = v
+		}
+		if tt == "httpupgrade" {
+			opts["v2ray-http-upgrade"] = true
+		}
+		p.set("ws-opts", opts)
+	case "grpc":
+		p.set("network", "grpc")
+		p.set("grpc-opts", map[string]any{"grpc-service-name": utils.AnyGet[string](t, "service_name")})
+	case "http":
+		host := anyStrings(t["host"])
+		path := utils.AnyGet[string](t, "path")
+		if tls {
+			p.set("network", "h2")
+			opts := map[string]any{"path": lo.CoalesceOrEmpty(path, "/")}
+			if len(host) != 0 {
+				opts["host"] = host
+			}
+			p.set("h2-opts", opts)
+			break
+		}
+		p.set("network", "http")
+		opts := map[string]any{"path": []any{lo.CoalesceOrEmpty(path, "/")}}
+		if v := utils.AnyGet[string](t, "method"); v != "" {
+			opts["method"] = v
+		}
+		if len(host) != 0 {
+			headers["Host"] = host
+		}
+		if len(headers) != 0 {
+			opts["headers"] = headers
+		}
+		p.set("http-opts", opts)
+	default:
+		return fmt.Errorf("clashTransport: unsupported transport %s", tt)
+	}
+	return nil
+}
+
+// clashSSPlugin sing-box 只支持 obfs-local 和 v2ray-plugin 两种插件
+func clashSSPlugin(p *clashProxy, out map[string]any) error {
+	plugin := utils.AnyGet[string](out, "plugin")
+	if plugin == "" {
+		return nil
+	}
+	opts := map[string]string{}
+	flags := map[string]bool{}
+	for _, v := range strings.Split(utils.AnyGet[string](out, "plugin_opts"), ";") {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok {
+			flags[k] = true
+			continue
+		}
+		opts[k] = strings.ReplaceAll(val, `\`, "")
+	}
+	switch plugin {
+	case "obfs-local":
+		p.set("plugin", "obfs")
+		po := map[string]any{"mode": opts["obfs"]}
+		if opts["obfs-host"] != "" {
+			po["host"] = opts["obfs-host"]
+		}
+		p.set("plugin-opts", po)
+	case "v2ray-plugin":
+		p.set("plugin", "v2ray-plugin")
+		po := map[string]any{"mode": lo.CoalesceOrEmpty(opts["mode"], "websocket")}
+		if flags["tls"] {
+			po["tls"] = true
+		}
+		if opts["host"] != "" {
+			po["host"] = opts["host"]
+		}
+		if opts["path"] != "" {
+			po["path"] = opts["path"]
+		}
+		if flags["mux"] {
+			po["mux"] = true
+		}
+		p.set("plugin-opts", po)
+	default:
+		return fmt.Errorf("clashSSPlugin: unsupported plugin %s", plugin)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+// hasFlag 名称中已经包含国旗
+func hasFlag(s string) bool {
+	for _, v := range s {
+		if v >= 0x1F1E6 && v <= 0x1F1FF {
+			return true
+		}
+	}
+	return false
+}
+
+type region struct {
+	code string
+	name string
+	r    *regexp.Regexp
+}
+
+// regions 内置的地区，包含国家和城市名、地区代码和机场代码，按顺序匹配，靠前的优先
+var regions = []region{
+	newRegion("HK", "香港", []string{"香港", "深港", "沪港", "广港", "京港", "莞港", "Hong Kong", "HongKong"}, []string{"HK", "HKG"}),
+	newRegion("MO", "澳门", []string{"澳门", "澳門", "Macao", "Macau"}, []string{"MO", "MAC", "MFM"}),
+	newRegion("TW", "台湾", []string{"台湾", "台灣", "臺灣", "台北", "台中", "新北", "Taiwan", "Taipei"}, []string{"TW", "TWN", "TPE"}),
+	newRegion("JP", "日本", []string{"日本", "东京", "東京", "大阪", "埼玉", "Japan", "Tokyo", "Osaka"}, []string{"JP", "JPN", "NRT", "HND", "KIX"}),
+	newRegion("KR", "韩国", []string{"韩国", "韓國", "首尔", "首爾", "春川", "Korea", "Seoul"}, []string{"KR", "KOR", "ICN"}),
+	newRegion("SG", "新加坡", []string{"新加坡", "狮城", "獅城", "Singapore"}, []string{"SG", "SGP", "SIN"}),
+	newRegion("US", "美国", []string{"美国", "美國", "洛杉矶", "洛杉磯", "圣何塞", "聖何塞", "硅谷", "西雅图", "芝加哥", "纽约", "紐約", "达拉斯", "凤凰城", "United States", "America", "Los Angeles", "San Jose", "Silicon Valley", "Seattle", "Chicago", "New York", "Dallas", "Phoenix"}, []string{"US", "USA", "LAX", "SJC", "SEA", "ORD", "JFK", "DFW"}),
+	newRegion("CA", "加拿大", []string{"加拿大", "多伦多", "温哥华", "蒙特利尔", "Canada", "Toronto", "Vancouver", "Montreal"}, []string{"CA", "CAN", "YYZ", "YVR"}),
+	newRegion("GB", "英国", []string{"英国", "英國", "伦敦", "倫敦", "United Kingdom", "Britain", "England", "London"}, []string{"UK", "GB", "GBR", "LHR"}),
+	newRegion("DE", "德国", []string{"德国", "德國", "法兰克福", "Germany", "Frankfurt"}, []string{"DE", "DEU"}),
+	newRegion("FR", "法国", []string{"法国", "法國", "巴黎", "France", "Paris"}, []string{"FR", "FRA", "CDG"}),
+	newRegion("NL", "荷兰", []string{"荷兰", "荷蘭", "阿姆斯特丹", "Netherlands", "Amsterdam"}, []string{"NL", "NLD", "AMS"}),
+	newRegion("RU", "俄罗斯", []string{"俄罗斯", "俄羅斯", "莫斯科", "Russia", "Moscow"}, []string{"RU", "RUS", "SVO"}),
+	newRegion("TR", "土耳其", []string{"土耳其", "伊斯坦布尔", "Turkey", "Türkiye", "Istanbul"}, []string{"TR", "TUR", "IST"}),
+	newRegion("AU", "澳大利亚", []string{"澳大利亚", "澳洲", "悉尼", "墨尔本", "Australia", "Sydney", "Melbourne"}, []string{"AU", "AUS", "SYD"}),
+	newRegion("ID", "印度尼西亚", []string{"印尼", "印度尼西亚", "雅加达", "Indonesia", "Jakarta"}, []string{"ID", "IDN", "CGK"}),
+	newRegion("IN", "印度", []string{"印度", "孟买", "India", "Mumbai"}, []string{"IN", "IND", "BOM"}),
+	newRegion("TH", "泰国", []string{"泰国", "泰國", "曼谷", "Thailand", "Bangkok"}, []string{"TH", "THA", "BKK"}),
+	newRegion("VN", "越南", []string{"越南", "胡志明", "河内", "Vietnam", "Hanoi"}, []string{"VN", "VNM", "SGN"}),
+	newRegion("PH", "菲律宾", []string{"菲律宾", "菲律賓", "马尼拉", "Philippines", "Manila"}, []string{"PH", "PHL", "MNL"}),
+	newRegion("MY", "马来西亚", []string{"马来西亚", "馬來西亞", "吉隆坡", "Malaysia", "Kuala Lumpur"}, []string{"MY", "MYS", "KUL"}),
+	newRegion("AR", "阿根廷", []string{"阿根廷", "Argentina"}, []string{"AR", "ARG"}),
+	newRegion("BR", "巴西", []string{"巴西", "圣保罗", "Brazil", "Sao Paulo"}, []string{"BR", "BRA", "GRU"}),
+	newRegion("CN", "中国", []string{"中国", "中國", "回国", "回國", "China"}, []string{"CN", "CHN"}),
+}
+
+// newRegion keywords 不区分大小写，英文的前后不能紧挨字母，codes 需要大写且前后不能紧挨字母，前面也不能是数字或数字加空格，避免把 10GB、10 GB 等流量信息当作地区。
+// 中文没有单词边界，不要使用单个字作为关键词，如「港」会匹配到「港口」
+func newRegion(code, name string, keywords, codes []string) region {
+	return region{
+		code: code,
+		name: name,
+		r:    regexp.MustCompile(regionRegexp(keywords, codes)),
+	}
+}
+
+func regionRegexp(keywords, codes []string) string {
+	parts := make([]string, 0, 2)
+	if len(keywords) != 0 {
+		k := make([]string, 0, len(keywords))
+		for _, v := range keywords {
+			q := regexp.QuoteMeta(v)
+			if isASCII(v) {
+				q = `(?:^|[^A-Za-z])` + q + `(?:[^A-Za-z]|$)`
+			}
+			k = append(k, q)
+		}
+		parts = append(parts, `(?i:`+strings.Join(k, "|")+`)`)
+	}
+	if len(codes) != 0 {
+		parts = append(parts, `(?:^|[^A-Za-z0-9\s]|(?:^|[^0-9\s])\s+)(?:`+strings.Join(codes, "|")+`)(?:[^A-Za-z]|$)`)
+	}
+	return strings.Join(parts, "|")
+}
+
+func isASCII(s string) bool {
+	for _, c := range s {
+		if c > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+func regionFlag(name string) string {
+	r, ok := matchRegion(regions, name)
+	if !ok {
+		return ""
+	}
+	return flagEmoji(r.code)
+}
+
+// matchRegion 名称中有国旗时优先按国旗判断，否则使用第一个匹配的地区
+func matchRegion(list []region, name string) (region, bool) {
+	if code := flagCode(name); code != "" {
+		for _, v := range list {
+			if v.code == code {
+				return v, true
+			}
+		}
+	}
+	for _, v := range list {
+		if v.r.MatchString(name) {
+			return v, true
+		}
+	}
+	return region{}, false
+}
+
+// flagCode 返回名称中第一个国旗对应的地区代码
+func flagCode(name string) string {
+	var prev rune
+	for _, v := range name {
+		if v >= 0x1F1E6 && v <= 0x1F1FF {
+			if prev != 0 {
+				return string([]rune{'A' + prev - 0x1F1E6, 'A' + v - 0x1F1E6})
+			}
+			prev = v
+			continue
+		}
+		prev = 0
+	}
+	return ""
+}
+
+func isRegionCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// flagEmoji 将两位地区代码转换为国旗 emoji
+func flagEmoji(code string) string {
+	var b strings.Builder
+	for _, c := range code {
+		b.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return b.String()
+}
+
+// regionList 合并自定义的地区和内置地区，自定义的新地区优先匹配
+func regionList(custom []model.Region) ([]region, error) {
+	list := slices.Clone(regions)
+	added := []region{}
+	for _, v := range custom {
+		code := strings.TrimSpace(v.Code)
+		if code == "" {
+			continue
+		}
+		i := slices.IndexFunc(list, func(r region) bool {
+			return r.code == code
+		})
+		r := region{code: code, name: v.Name}
+		if i != -1 {
+			r = list[i]
+			if v.Name != "" {
+				r.name = v.Name
+			}
+		}
+		if r.name == "" {
+			r.name = code
+		}
+		switch {
+		case v.Match != "":
+			re, err := regexp.Compile(v.Match)
+			if err != nil {
+				return nil, fmt.Errorf("regionList: %w: %w", ErrFilter, err)
+			}
+			r.r = re
+		case r.r == nil:
+			r.r = regexp.MustCompile(regionRegexp(nil, []string{regexp.QuoteMeta(code)}))
+		}
+		if i != -1 {
+			list[i] = r
+		} else {
+			added = append(added, r)
+		}
+	}
+	return append(added, list...), nil
+}
+
+// applyRegionGroups 按地区把节点分组，每个地区生成一个 selector 和一个 urltest，并加入主 select。
+// urlTest 为 false 时只生成 selector
+func applyRegionGroups(config map[string]any, tags []TagWithVisible, opt *model.AutoRegionGroups, urlTest bool) (map[string]any, []model.GroupReport, error) {
+	if opt == nil {
+		return config, nil, nil
+	}
+	list, err := regionList(opt.Regions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("applyRegionGroups: %w", err)
+	}
+	minNodes := max(opt.MinNodes, 1)
+
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	urlTestOpt := regionUrlTest(outbounds, opt)
+	// 只在原有的出站中查找主 select，避免把地区策略组加入到新生成的 selector 中
+	origin := len(outbounds)
+	exist := map[string]struct{}{}
+	for _, v := range outbounds {
+		exist[outboundTag(v)] = struct{}{}
+	}
+
+	nodes := map[string][]string{}
+	nodeSet := map[string]struct{}{}
+	for _, v := range tags {
+		if len(v.Visible) != 0 {
+			continue
+		}
+		nodeSet[v.Tag] = struct{}{}
+		r, ok := matchRegion(list, v.Tag)
+		if !ok {
+			continue
+		}
+		nodes[r.code] = append(nodes[r.code], v.Tag)
+	}
+
+	index := func(code string) int {
+		i := slices.Index(opt.Order, code)
+		if i == -1 {
+			return len(opt.Order)
+		}
+		return i
+	}
+	list = lo.Filter(list, func(item region, _ int) bool {
+		return len(nodes[item.code]) >= minNodes
+	})
+	slices.SortStableFunc(list, func(a, b region) int {
+		if c := cmp.Compare(index(a.code), index(b.code)); c != 0 || !opt.SortByCount {
+			return c
+		}
+		return cmp.Compare(len(nodes[b.code]), len(nodes[a.code]))
+	})
+
+	report := []model.GroupReport{}
+	selectors := []string{}
+	for _, r := range list {
+		tag := r.name
+		if isRegionCode(r.code) {
+			tag = flagEmoji(r.code) + " " + r.name
+		}
+		autoTag := tag + "自动选择"
+		_, ok1 := exist[tag]
+		_, ok2 := exist[autoTag]
+		if ok1 || (urlTest && ok2) {
+			continue
+		}
+		n := nodes[r.code]
+		members := n
+		if urlTest {
+			members = append([]string{autoTag}, n...)
+		}
+		outbounds = append(outbounds, map[string]any{
+			"type":      "selector",
+			"tag":       tag,
+			"outbounds": lo.ToAnySlice(members),
+		})
+		report = append(report, model.GroupReport{
+			Tag:       tag,
+			Type:      "selector",
+			Matched:   n,
+			Outbounds: members,
+		})
+		if urlTest {
+			ut := map[string]any{
+				"type":      "urltest",
+				"tag":       autoTag,
+				"outbounds": lo.ToAnySlice(n),
+			}
+			maps.Copy(ut, urlTestOpt)
+			outbounds = append(outbounds, ut)
+			report = append(report, model.GroupReport{
+				Tag:       autoTag,
+				Type:      "urltest",
+				Matched:   n,
+				Outbounds: n,
+			})
+		}
+		selectors = append(selectors, tag)
+	}
+
+	insertMainSelector(outbounds[:origin], selectors, nodeSet)
+	utils.AnySet(&config, outbounds, "outbounds")
+	return config, report, nil
+}
+
+// regionUrlTest 生成的 urltest 的 url、interval 和 tolerance，
+// 优先使用参数，其次是模板中第一个设置了该项的 urltest，最后与 proxyGroups 的默认值相同
+func regionUrlTest(outbounds []any, opt *model.AutoRegionGroups) map[string]any {
+	m := map[string]any{}
+	if opt.URL != "" {
+		m["url"] = opt.URL
+	}
+	if opt.Interval != "" {
+		m["interval"] = opt.Interval
+	}
+	if opt.Tolerance != 0 {
+		m["tolerance"] = opt.Tolerance
+	}
+	for _, v := range outbounds {
+		out, ok := v.(map[string]any)
+		if !ok || utils.AnyGet[string](out, "type") != "urltest" {
+			continue
+		}
+		for _, k := range []string{"url", "interval", "tolerance"} {
+			if _, ok := m[k]; ok {
+				continue
+			}
+			if v, ok := out[k]; ok {
+				m[k] = v
+			}
+		}
+	}
+	defaults := map[string]any{
+		"url":       "https://cp.cloudflare.com/generate_204",
+		"interval":  "10m",
+		"tolerance": 50,
+	}
+	for k, v := range defaults {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// insertMainSelector 把 tags 插入主 selector 中第一个节点之前
+func insertMainSelector(outbounds []any, tags []string, nodeSet map[string]struct{}) {
+	i := mainSelector(outbounds)
+	if i == -1 || len(tags) == 0 {
+		return
+	}
+	insert := func(outs []string) []string {
+		i := slices.IndexFunc(outs, func(item string) bool {
+			_, ok := nodeSet[item]
+			return ok
+		})
+		if i == -1 {
+			i = len(outs)
+		}
+		return slices.Insert(outs, i, tags...)
+	}
+	switch v := outbounds[i].(type) {
+	case singbox.SingBoxOut:
+		v.Outbounds = insert(v.Outbounds)
+		outbounds[i] = v
+	case map[string]any:
+		outs := lo.FilterMap(utils.AnyGet[[]any](v, "outbounds"), func(item any, _ int) (string, bool) {
+			s, ok := item.(string)
+			return s, ok
+		})
+		v["outbounds"] = lo.ToAnySlice(insert(outs))
+	}
+}
+
+// mainSelector 返回 tag 为 select 的 selector 的位置，没有时返回第一个 selector
+func mainSelector(outbounds []any) int {
+	first := -1
+	for i, v := range outbounds {
+		if outboundType(v) != "selector" {
+			continue
+		}
+		if outboundTag(v) == "select" {
+			return i
+		}
+		if first == -1 {
+			first = i
+		}
+	}
+	return first
+}
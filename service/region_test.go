@@ -0,0 +1,143 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+func TestMatchRegion(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"香港 01", "HK"},
+		{"深港专线 01", "HK"},
+		{"HK-01", "HK"},
+		{"hongkong 01", "HK"},
+		{"Hong Kong", "HK"},
+		{"🇯🇵 香港中转", "JP"},
+		{"日本 东京", "JP"},
+		{"韩国 01", "KR"},
+		{"US LAX 01", "US"},
+		{"美国 洛杉矶", "US"},
+		{"FRA 01", "FR"},
+		{"Frankfurt", "DE"},
+		{"UK London", "GB"},
+		{"新加坡", "SG"},
+		// 英文关键词和代码前后不能紧挨字母
+		{"THKG 01", ""},
+		{"Chinatown", ""},
+		{"USA?", "US"},
+		{"Usa", ""},
+		{"us 01", ""},
+		{"港口", ""},
+		{"剩余流量：10GB", ""},
+		{"剩余流量：10 GB", ""},
+		{"剩余 1.5 TB | 香港", "HK"},
+		{"香港 HK", "HK"},
+		{"Node UK", "GB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := matchRegion(regions, tt.name)
+			if r.code != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q %v, want %q", r.code, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegionList(t *testing.T) {
+	list, err := regionList([]model.Region{
+		{Code: "HK", Name: "港区"},
+		{Code: "XX", Name: "自定义", Match: "(?i)custom"},
+		{Code: "JP", Match: "^JP"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		want     string
+		wantName string
+	}{
+		{"HK 01", "HK", "港区"},
+		{"Custom HK", "XX", "自定义"},
+		{"JP 01", "JP", "日本"},
+		{"日本 01", "", ""},
+	}
+	for _, tt := range tests {
+		r, ok := matchRegion(list, tt.name)
+		if r.code != tt.want || r.name != tt.wantName || ok != (tt.want != "") {
+			t.Errorf("%s: got %q %q, want %q %q", tt.name, r.code, r.name, tt.want, tt.wantName)
+		}
+	}
+
+	_, err = regionList([]model.Region{{Code: "XX", Match: "("}})
+	if err == nil {
+		t.Error("want error for invalid match")
+	}
+}
+
+func TestApplyRegionGroups(t *testing.T) {
+	const config = `{"outbounds":[
+		{"type":"selector","tag":"select","outbounds":["auto","direct","HK 1","HK 2","JP 1","US 1"]},
+		{"type":"urltest","tag":"auto","outbounds":["HK 1","HK 2","JP 1","US 1"],"interval":"5m"},
+		{"type":"direct","tag":"direct"}
+	]}`
+	tags := []TagWithVisible{{Tag: "HK 1"}, {Tag: "HK 2"}, {Tag: "JP 1"}, {Tag: "US 1"}, {Tag: "US chain", Visible: []string{"g"}}}
+	tests := []struct {
+		name    string
+		opt     *model.AutoRegionGroups
+		urlTest bool
+		want    string
+		report  int
+	}{
+		{
+			name:    "nil",
+			urlTest: true,
+			want:    config,
+		},
+		{
+			name:    "urltest from template",
+			opt:     &model.AutoRegionGroups{Order: []string{"JP"}, MinNodes: 1, Tolerance: 100},
+			urlTest: true,
+			want: `{"outbounds":[
+				{"type":"selector","tag":"select","outbounds":["auto","direct","🇯🇵 日本","🇭🇰 香港","🇺🇸 美国","HK 1","HK 2","JP 1","US 1"]},
+				{"type":"urltest","tag":"auto","outbounds":["HK 1","HK 2","JP 1","US 1"],"interval":"5m"},
+				{"type":"direct","tag":"direct"},
+				{"type":"selector","tag":"🇯🇵 日本","outbounds":["🇯🇵 日本自动选择","JP 1"]},
+				{"type":"urltest","tag":"🇯🇵 日本自动选择","outbounds":["JP 1"],"url":"https://cp.cloudflare.com/generate_204","interval":"5m","tolerance":100},
+				{"type":"selector","tag":"🇭🇰 香港","outbounds":["🇭🇰 香港自动选择","HK 1","HK 2"]},
+				{"type":"urltest","tag":"🇭🇰 香港自动选择","outbounds":["HK 1","HK 2"],"url":"https://cp.cloudflare.com/generate_204","interval":"5m","tolerance":100},
+				{"type":"selector","tag":"🇺🇸 美国","outbounds":["🇺🇸 美国自动选择","US 1"]},
+				{"type":"urltest","tag":"🇺🇸 美国自动选择","outbounds":["US 1"],"url":"https://cp.cloudflare.com/generate_204","interval":"5m","tolerance":100}
+			]}`,
+			report: 6,
+		},
+		{
+			name: "min nodes without urltest",
+			opt:  &model.AutoRegionGroups{MinNodes: 2},
+			want: `{"outbounds":[
+				{"type":"selector","tag":"select","outbounds":["auto","direct","🇭🇰 香港","HK 1","HK 2","JP 1","US 1"]},
+				{"type":"urltest","tag":"auto","outbounds":["HK 1","HK 2","JP 1","US 1"],"interval":"5m"},
+				{"type":"direct","tag":"direct"},
+				{"type":"selector","tag":"🇭🇰 香港","outbounds":["HK 1","HK 2"]}
+			]}`,
+			report: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, report, err := applyRegionGroups(jsonMap(t, config), tags, tt.opt, tt.urlTest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, m, tt.want)
+			if len(report) != tt.report {
+				t.Errorf("report = %+v, want %d", report, tt.report)
+			}
+		})
+	}
+}
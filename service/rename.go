@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+type renameRule struct {
+	r       *regexp.Regexp
+	replace string
+}
+
+// renamer 按顺序执行重命名规则，可选在名称前加上国旗
+type renamer struct {
+	rules []renameRule
+	emoji bool
+}
+
+func newRenamer(rules []model.RenameRule, emoji bool) (*renamer, error) {
+	if len(rules) == 0 && !emoji {
+		return nil, nil
+	}
+	r := &renamer{
+		rules: make([]renameRule, 0, len(rules)),
+		emoji: emoji,
+	}
+	for _, v := range rules {
+		if v.Match == "" {
+			continue
+		}
+		re, err := regexp.Compile(v.Match)
+		if err != nil {
+			return nil, fmt.Errorf("newRenamer: %w: %w", ErrFilter, err)
+		}
+		r.rules = append(r.rules, renameRule{r: re, replace: v.Replace})
+	}
+	return r, nil
+}
+
+func (r *renamer) name(tag string) string {
+	name := tag
+	for _, v := range r.rules {
+		name = v.r.ReplaceAllString(name, v.replace)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = tag
+	}
+	if r.emoji && !hasFlag(name) {
+		f := regionFlag(name)
+		if f == "" {
+			f = regionFlag(tag)
+		}
+		if f != "" {
+			name = f + " " + name
+		}
+	}
+	return name
+}
+
+// renameNodes 重命名订阅中的节点，并同步修改节点之间的 detour 引用。
+// 重命名后与模板中已有的 tag 或其他节点重复时，会在末尾加上序号，
+// 返回修改后的 tags 和原 tag 到新 tag 的对应。
+func renameNodes(s []singbox.SingBoxOut, outs []map[string]any, tags []string, r *renamer, reserved []string) ([]string, map[string]string) {
+	if r == nil {
+		return tags, nil
+	}
+	used := make(map[string]struct{}, len(reserved)+len(s)+len(outs))
+	for _, v := range reserved {
+		used[v] = struct{}{}
+	}
+	for k := range notNeedTag {
+		used[k] = struct{}{}
+	}
+	rename := map[string]string{}
+
+	uniq := func(tag string) string {
+		if n, ok := rename[tag]; ok {
+			return n
+		}
+		name := r.name(tag)
+		n := name
+		for i := 2; ; i++ {
+			if _, ok := used[n]; !ok {
+				break
+			}
+			n = name + " " + strconv.Itoa(i)
+		}
+		used[n] = struct{}{}
+		rename[tag] = n
+		return n
+	}
+
+	for i := range s {
+		s[i].Tag = uniq(s[i].Tag)
+	}
+	for _, v := range outs {
+		v["tag"] = uniq(utils.AnyGet[string](v, "tag"))
+	}
+
+	for i := range s {
+		if n, ok := rename[s[i].Detour]; ok {
+			s[i].Detour = n
+		}
+	}
+	for _, v := range outs {
+		if n, ok := rename[utils.AnyGet[string](v, "detour")]; ok {
+			v["detour"] = n
+		}
+	}
+
+	newTags := make([]string, 0, len(tags))
+	for _, v := range tags {
+		if n, ok := rename[v]; ok {
+			v = n
+		}
+		newTags = append(newTags, v)
+	}
+	return newTags, rename
+}
+
+// memberRename 策略组成员使用的重命名对应，原名称与模板中的 tag 相同时仍然指向模板中的出站
+func memberRename(rename map[string]string, reserved []string) map[string]string {
+	if len(rename) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(rename))
+	for k, v := range rename {
+		if k != v && !slices.Contains(reserved, k) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// renameOutbounds 模板中的策略组按原名称引用节点时，outbounds、default 和 detour 改为重命名后的名称
+func renameOutbounds(out map[string]any, rename map[string]string) {
+	if len(rename) == 0 {
+		return
+	}
+	list := utils.AnyGet[[]any](out, "outbounds")
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if n, ok := rename[s]; ok {
+			list[i] = n
+		}
+	}
+	for _, k := range []string{"default", "detour"} {
+		if n, ok := rename[utils.AnyGet[string](out, k)]; ok {
+			out[k] = n
+		}
+	}
+}
+
+// renameProxyGroups 同 renameOutbounds，修改请求中策略组的 outbounds、default 和 downloadDetour
+func renameProxyGroups(groups []model.ProxyGroup, rename map[string]string) []model.ProxyGroup {
+	if len(rename) == 0 || len(groups) == 0 {
+		return groups
+	}
+	groups = slices.Clone(groups)
+	for i, g := range groups {
+		g.Outbounds = slices.Clone(g.Outbounds)
+		for j, v := range g.Outbounds {
+			if n, ok := rename[strings.TrimSpace(v)]; ok {
+				g.Outbounds[j] = n
+			}
+		}
+		if n, ok := rename[g.Default]; ok {
+			g.Default = n
+		}
+		if n, ok := rename[g.DownloadDetour]; ok {
+			g.DownloadDetour = n
+		}
+		groups[i] = g
+	}
+	return groups
+}
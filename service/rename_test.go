@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2singbox/model/singbox"
+)
+
+func TestRenameNodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      []model.RenameRule
+		s          []singbox.SingBoxOut
+		outs       string
+		tags       []string
+		reserved   []string
+		wantS      [][2]string
+		wantOuts   string
+		wantTags   []string
+		wantRename map[string]string
+	}{
+		{
+			name:       "detour",
+			rules:      []model.RenameRule{{Match: `-0*`, Replace: " "}},
+			s:          []singbox.SingBoxOut{{Tag: "hk-01"}, {Tag: "us-01", Detour: "hk-01"}},
+			outs:       `[{"type":"socks","tag":"jp-01","detour":"us-01"}]`,
+			tags:       []string{"jp-01"},
+			wantS:      [][2]string{{"hk 1", ""}, {"us 1", "hk 1"}},
+			wantOuts:   `[{"type":"socks","tag":"jp 1","detour":"us 1"}]`,
+			wantTags:   []string{"jp 1"},
+			wantRename: map[string]string{"hk-01": "hk 1", "us-01": "us 1", "jp-01": "jp 1"},
+		},
+		{
+			name:       "collision",
+			rules:      []model.RenameRule{{Match: `\s*\d+$`}},
+			s:          []singbox.SingBoxOut{{Tag: "HK 01"}, {Tag: "HK 02"}, {Tag: "US 01", Detour: "HK 02"}},
+			outs:       `[{"type":"socks","tag":"HK 03"}]`,
+			tags:       []string{"HK 03"},
+			reserved:   []string{"HK", "select"},
+			wantS:      [][2]string{{"HK 2", ""}, {"HK 3", ""}, {"US", "HK 3"}},
+			wantOuts:   `[{"type":"socks","tag":"HK 4"}]`,
+			wantTags:   []string{"HK 4"},
+			wantRename: map[string]string{"HK 01": "HK 2", "HK 02": "HK 3", "US 01": "US", "HK 03": "HK 4"},
+		},
+		{
+			name:       "empty name keeps the tag",
+			rules:      []model.RenameRule{{Match: `.*`}},
+			s:          []singbox.SingBoxOut{{Tag: "a"}, {Tag: "direct"}},
+			outs:       `[]`,
+			wantS:      [][2]string{{"a", ""}, {"direct 2", ""}},
+			wantOuts:   `[]`,
+			wantTags:   []string{},
+			wantRename: map[string]string{"a": "a", "direct": "direct 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rn, err := newRenamer(tt.rules, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outs := jsonList(t, tt.outs)
+			tags, rename := renameNodes(tt.s, outs, tt.tags, rn, tt.reserved)
+			got := lo.Map(tt.s, func(item singbox.SingBoxOut, _ int) [2]string { return [2]string{item.Tag, item.Detour} })
+			if !reflect.DeepEqual(got, tt.wantS) {
+				t.Errorf("s = %q, want %q", got, tt.wantS)
+			}
+			jsonEqual(t, outs, tt.wantOuts)
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("tags = %q, want %q", tags, tt.wantTags)
+			}
+			if !reflect.DeepEqual(rename, tt.wantRename) {
+				t.Errorf("rename = %v, want %v", rename, tt.wantRename)
+			}
+		})
+	}
+}
+
+// TestRenameMembers 模板和请求中的策略组按原名称引用节点，重命名后链式节点也使用新的名称
+func TestRenameMembers(t *testing.T) {
+	s := []singbox.SingBoxOut{{Type: "trojan", Tag: "HK 01"}, {Type: "trojan", Tag: "US 01"}, {Type: "trojan", Tag: "g"}}
+	outs := jsonList(t, `[
+		{"type":"selector","tag":"g","outbounds":["HK 01","direct"],"default":"HK 01"},
+		{"type":"selector","tag":"chain","outbounds":["US 01"],"detour":"HK 01"},
+		{"type":"direct","tag":"direct"}
+	]`)
+	reserved := []string{"g", "chain", "direct"}
+	rn, err := newRenamer([]model.RenameRule{{Match: ` 0`, Replace: " "}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rename := renameNodes(s, nil, nil, rn, reserved)
+	member := memberRename(rename, reserved)
+	if want := map[string]string{"HK 01": "HK 1", "US 01": "US 1"}; !reflect.DeepEqual(member, want) {
+		t.Errorf("member = %v, want %v", member, want)
+	}
+	for _, v := range outs {
+		renameOutbounds(v, member)
+	}
+	jsonEqual(t, outs, `[
+		{"type":"selector","tag":"g","outbounds":["HK 1","direct"],"default":"HK 1"},
+		{"type":"selector","tag":"chain","outbounds":["US 1"],"detour":"HK 1"},
+		{"type":"direct","tag":"direct"}
+	]`)
+
+	groups := []model.ProxyGroup{{Tag: "a", Outbounds: []string{" US 01", "g"}, Default: "US 01", DownloadDetour: "HK 01"}}
+	got := renameProxyGroups(groups, member)
+	want := []model.ProxyGroup{{Tag: "a", Outbounds: []string{"US 1", "g"}, Default: "US 1", DownloadDetour: "HK 1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %+v, want %+v", got, want)
+	}
+	if groups[0].Outbounds[0] != " US 01" {
+		t.Error("request groups changed")
+	}
+
+	all, _, _ := urlTestDetourSet(s, outs, []string{"direct"})
+	visible := lo.FilterMap(all, func(item singbox.SingBoxOut, _ int) (string, bool) {
+		return item.Tag, len(item.Visible) != 0
+	})
+	if want := []string{"US 1 - HK 1 [chain]", "g 2 - HK 1 [chain]", "direct - HK 1 [chain]"}; !reflect.DeepEqual(visible, want) {
+		t.Errorf("visible = %q, want %q", visible, want)
+	}
+}
+
+func jsonList(t *testing.T, s string) []map[string]any {
+	t.Helper()
+	var l []map[string]any
+	err := json.Unmarshal([]byte(s), &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/xmdhs/clash2sfa/model"
+	cmodel "github.com/xmdhs/clash2singbox/model"
+)
+
+// templateData 模板中可以使用的值，Version 为 1.10、1.11、1.12 或 latest
+type templateData struct {
+	ProxyPort int
+	ProxyType string
+	EnableTun bool
+	Version   string
+	vars      map[string]string
+}
+
+// jsonString json 字符串转义后的内容，不含两边的引号，放在模板的字符串中时不会破坏 json 的结构
+type jsonString string
+
+// Param 返回转义后的 var.* 参数，没有时为空。只能写在模板的字符串中，其他位置需要使用 | json
+func (d templateData) Param(name string) jsonString {
+	b, _ := json.Marshal(d.vars[name])
+	return jsonString(b[1 : len(b)-1])
+}
+
+var errTooLarge = errors.New("output too large")
+
+// limitWriter 超过 n 字节后返回错误，避免模板生成过大的配置
+type limitWriter struct {
+	w *bytes.Buffer
+	n int
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.w.Len()+len(p) > l.n {
+		return 0, errTooLarge
+	}
+	return l.w.Write(p)
+}
+
+// renderTemplate 执行模板中的 {{ }}，没有时原样返回。
+// 只允许输出、if 和 with，不允许 range 和定义子模板，比较函数对版本号按版本比较。
+func renderTemplate(tpl []byte, arg model.ConvertArg) ([]byte, error) {
+	if !bytes.Contains(tpl, []byte("{{")) {
+		return tpl, nil
+	}
+	t, err := template.New("config").Option("missingkey=zero").Funcs(templateFuncs).Parse(string(tpl))
+	if err != nil {
+		return nil, fmt.Errorf("renderTemplate: %w: %w", ErrTemplate, err)
+	}
+	if len(t.Templates()) > 1 {
+		return nil, fmt.Errorf("renderTemplate: %w: define and block are not allowed", ErrTemplate)
+	}
+	if name := disallowedNode(t.Tree.Root); name != "" {
+		return nil, fmt.Errorf("renderTemplate: %w: %s is not allowed", ErrTemplate, name)
+	}
+	proxyPort, proxyType := arg.ProxyPort, arg.ProxyType
+	if proxyPort <= 0 {
+		proxyPort = 7890
+	}
+	if proxyType == "" {
+		proxyType = "mixed"
+	}
+	data := templateData{
+		ProxyPort: proxyPort,
+		ProxyType: proxyType,
+		EnableTun: arg.EnableTun,
+		Version:   versionName(arg.Ver),
+		vars:      arg.Vars,
+	}
+	bw := &bytes.Buffer{}
+	err = t.Execute(&limitWriter{w: bw, n: 1000 * 1000 * 10}, data)
+	if err != nil {
+		return nil, fmt.Errorf("renderTemplate: %w: %w", ErrTemplate, err)
+	}
+	return bw.Bytes(), nil
+}
+
+func disallowedNode(n parse.Node) string {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return ""
+		}
+		for _, v := range n.Nodes {
+			if name := disallowedNode(v); name != "" {
+				return name
+			}
+		}
+	case *parse.IfNode:
+		return cmp.Or(disallowedNode(n.List), disallowedNode(n.ElseList))
+	case *parse.WithNode:
+		return cmp.Or(disallowedNode(n.List), disallowedNode(n.ElseList))
+	case *parse.RangeNode:
+		return "range"
+	case *parse.TemplateNode:
+		return "template"
+	}
+	return ""
+}
+
+func versionName(ver cmodel.SingBoxVer) string {
+	switch ver {
+	case cmodel.SING110:
+		return "1.10"
+	case cmodel.SING111:
+		return "1.11"
+	case cmodel.SING112:
+		return "1.12"
+	}
+	return "latest"
+}
+
+// templateFuncs json 把值转为 json，用于在字符串中安全地插入参数；default 在值为空时使用默认值
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		// 已经转义过的参数只需要加上引号
+		if s, ok := v.(jsonString); ok {
+			return `"` + string(s) + `"`, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def, v any) any {
+		if v == nil || reflect.ValueOf(v).IsZero() {
+			return def
+		}
+		return v
+	},
+	"lt": func(a, b any) (bool, error) { c, err := templateCompare(a, b); return c < 0, err },
+	"le": func(a, b any) (bool, error) { c, err := templateCompare(a, b); return c <= 0, err },
+	"gt": func(a, b any) (bool, error) { c, err := templateCompare(a, b); return c > 0, err },
+	"ge": func(a, b any) (bool, error) { c, err := templateCompare(a, b); return c >= 0, err },
+}
+
+// templateCompare 两个字符串都是版本号时按版本比较，其余与内置的比较函数相同
+func templateCompare(a, b any) (int, error) {
+	as, aok := templateString(a)
+	bs, bok := templateString(b)
+	if aok && bok {
+		av, aerr := templateVersion(as)
+		bv, berr := templateVersion(bs)
+		if aerr == nil && berr == nil {
+			return av.Compare(bv), nil
+		}
+		return strings.Compare(as, bs), nil
+	}
+	af, aok := templateNumber(a)
+	bf, bok := templateNumber(b)
+	if aok && bok {
+		return cmp.Compare(af, bf), nil
+	}
+	return 0, fmt.Errorf("incompatible types for comparison: %T and %T", a, b)
+}
+
+func templateVersion(s string) (*semver.Version, error) {
+	if s == "latest" {
+		return semver.New(1, math.MaxUint32, 0, "", ""), nil
+	}
+	return semver.NewVersion(s)
+}
+
+func templateString(v any) (string, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.String {
+		return "", false
+	}
+	return rv.String(), true
+}
+
+func templateNumber(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int()), true
+	case rv.CanUint():
+		return float64(rv.Uint()), true
+	case rv.CanFloat():
+		return rv.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xmdhs/clash2sfa/model"
+	cmodel "github.com/xmdhs/clash2singbox/model"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tpl     string
+		arg     model.ConvertArg
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no action unchanged",
+			tpl:  `{"a":"{ }"}`,
+			want: `{"a":"{ }"}`,
+		},
+		{
+			name: "defaults",
+			tpl:  `{"port":{{ .ProxyPort }},"type":{{ .ProxyType | json }},"tun":{{ .EnableTun }},"ver":"{{ .Version }}"}`,
+			arg:  model.ConvertArg{Ver: cmodel.SINGLATEST},
+			want: `{"port":7890,"type":"mixed","tun":false,"ver":"latest"}`,
+		},
+		{
+			name: "if else with",
+			tpl:  `{{ if .EnableTun }}tun{{ else }}{{ with .ProxyType }}{{ . }}{{ end }}{{ end }}`,
+			arg:  model.ConvertArg{ProxyType: "http"},
+			want: `http`,
+		},
+		{
+			name: "param is escaped",
+			tpl:  `{"a":"{{ .Param "a" }}","b":{{ .Param "a" | json }},"c":"{{ .Param "none" }}"}`,
+			arg:  model.ConvertArg{Vars: map[string]string{"a": `x","y":"<z>`}},
+			want: `{"a":"x\",\"y\":\"\u003cz\u003e","b":"x\",\"y\":\"\u003cz\u003e","c":""}`,
+		},
+		{
+			name: "default",
+			tpl:  `{{ .Param "a" | default "d" }},{{ .Param "b" | default "d" }}`,
+			arg:  model.ConvertArg{Vars: map[string]string{"a": "v"}},
+			want: `v,d`,
+		},
+		{
+			name:    "range",
+			tpl:     `{{ range .Version }}{{ end }}`,
+			wantErr: true,
+		},
+		{
+			name:    "range in if",
+			tpl:     `{{ if .EnableTun }}{{ else }}{{ range .Version }}{{ end }}{{ end }}`,
+			wantErr: true,
+		},
+		{
+			name:    "template",
+			tpl:     `{{ with .Version }}{{ template "config" }}{{ end }}`,
+			wantErr: true,
+		},
+		{
+			name:    "define",
+			tpl:     `{{ define "a" }}a{{ end }}{{ .Version }}`,
+			wantErr: true,
+		},
+		{
+			name:    "block",
+			tpl:     `{{ block "a" . }}a{{ end }}`,
+			wantErr: true,
+		},
+		{
+			name:    "parse error",
+			tpl:     `{{ if }}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := renderTemplate([]byte(tt.tpl), tt.arg)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTemplate) {
+					t.Fatalf("err = %v, want ErrTemplate", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got  %s\nwant %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateVersion(t *testing.T) {
+	tests := []struct {
+		ver  cmodel.SingBoxVer
+		tpl  string
+		want string
+	}{
+		// 按字符串比较时 "1.10" < "1.9"
+		{cmodel.SING110, `{{ if ge .Version "1.9" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING110, `{{ if lt .Version "1.11" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING111, `{{ if le .Version "1.11" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING112, `{{ if gt .Version "1.11" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING112, `{{ if ge .Version "1.12.3" }}y{{ else }}n{{ end }}`, "n"},
+		{cmodel.SINGLATEST, `{{ if gt .Version "1.99" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SINGLATEST, `{{ if eq .Version "latest" }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING110, `{{ if gt .ProxyPort 1024 }}y{{ else }}n{{ end }}`, "y"},
+		{cmodel.SING110, `{{ if lt "b" "a" }}y{{ else }}n{{ end }}`, "n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tpl, func(t *testing.T) {
+			b, err := renderTemplate([]byte(tt.tpl), model.ConvertArg{Ver: tt.ver})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+
+	_, err := renderTemplate([]byte(`{{ if lt .Version 1 }}{{ end }}`), model.ConvertArg{})
+	if !errors.Is(err, ErrTemplate) {
+		t.Errorf("err = %v, want ErrTemplate", err)
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2singbox/httputils"
+	"gopkg.in/yaml.v3"
+)
+
+// clashMatcher clash 规则中的一个匹配条件对应的 sing-box 字段
+type clashMatcher struct {
+	field string
+	value any
+}
+
+// clashRuleMatcher 转换 DOMAIN、IP-CIDR 等不依赖规则集的规则，不支持的类型返回 false
+func clashRuleMatcher(typ, value string) (clashMatcher, bool) {
+	switch strings.ToUpper(typ) {
+	case "DOMAIN":
+		return clashMatcher{"domain", value}, true
+	case "DOMAIN-SUFFIX":
+		return clashMatcher{"domain_suffix", value}, true
+	case "DOMAIN-KEYWORD":
+		return clashMatcher{"domain_keyword", value}, true
+	case "DOMAIN-REGEX":
+		return clashMatcher{"domain_regex", value}, true
+	case "IP-CIDR", "IP-CIDR6":
+		return clashMatcher{"ip_cidr", value}, true
+	case "SRC-IP-CIDR":
+		return clashMatcher{"source_ip_cidr", value}, true
+	case "PROCESS-NAME":
+		return clashMatcher{"process_name", value}, true
+	case "PROCESS-PATH":
+		return clashMatcher{"process_path", value}, true
+	case "DST-PORT":
+		if p, ok := parsePort(value); ok {
+			return clashMatcher{"port", p}, true
+		}
+	case "GEOIP":
+		if strings.EqualFold(value, "lan") {
+			return clashMatcher{"ip_is_private", true}, true
+		}
+	}
+	return clashMatcher{}, false
+}
+
+// domainMatcher rule-provider behavior 为 domain 时的一行
+func domainMatcher(s string) clashMatcher {
+	switch {
+	case strings.HasPrefix(s, "+."):
+		return clashMatcher{"domain_suffix", s[2:]}
+	case strings.HasPrefix(s, "."):
+		return clashMatcher{"domain_suffix", s}
+	case strings.Contains(s, "*"):
+		parts := strings.Split(s, "*")
+		for i, v := range parts {
+			parts[i] = regexp.QuoteMeta(v)
+		}
+		return clashMatcher{"domain_regex", "^" + strings.Join(parts, `[^.]+`) + "$"}
+	default:
+		return clashMatcher{"domain", s}
+	}
+}
+
+// headlessRules 相同字段的条件合并为一条规则，不同字段分为多条，规则集中多条规则之间是或的关系
+func headlessRules(matchers []clashMatcher) []any {
+	rules := []any{}
+	index := map[string]int{}
+	for _, v := range matchers {
+		if b, ok := v.value.(bool); ok {
+			rules = append(rules, map[string]any{v.field: b})
+			continue
+		}
+		i, ok := index[v.field]
+		if !ok {
+			index[v.field] = len(rules)
+			rules = append(rules, map[string]any{v.field: []any{v.value}})
+			continue
+		}
+		m := rules[i].(map[string]any)
+		m[v.field] = append(m[v.field].([]any), v.value)
+	}
+	return rules
+}
+
+func parsePort(s string) (int, bool) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, false
+	}
+	return p, true
+}
+
+// providerPayload 读取 clash rule-provider 的内容，format 为空时先按 yaml 解析，失败再按每行一条解析
+func providerPayload(b []byte, format string) ([]string, error) {
+	switch strings.ToLower(format) {
+	case "mrs":
+		return nil, fmt.Errorf("providerPayload: %w: mrs format is not supported", ErrFormat)
+	case "text":
+		return textPayload(b), nil
+	}
+	p := struct {
+		Payload []string `yaml:"payload"`
+	}{}
+	err := yaml.Unmarshal(b, &p)
+	if err == nil && len(p.Payload) != 0 {
+		return p.Payload, nil
+	}
+	if strings.ToLower(format) == "yaml" {
+		if err != nil {
+			return nil, fmt.Errorf("providerPayload: %w", err)
+		}
+		return nil, nil
+	}
+	return textPayload(b), nil
+}
+
+func textPayload(b []byte) []string {
+	list := []string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		list = append(list, line)
+	}
+	return list
+}
+
+// providerRules 把 rule-provider 的内容按 behavior 转换为 sing-box 规则集中的规则，返回无法转换的条数
+func providerRules(payload []string, behavior string) ([]any, int) {
+	matchers := make([]clashMatcher, 0, len(payload))
+	skipped := 0
+	for _, v := range payload {
+		v = strings.Trim(strings.TrimSpace(v), `'"`)
+		if v == "" {
+			continue
+		}
+		switch strings.ToLower(behavior) {
+		case "domain":
+			matchers = append(matchers, domainMatcher(v))
+		case "ipcidr":
+			matchers = append(matchers, clashMatcher{"ip_cidr", v})
+		default:
+			f := strings.Split(v, ",")
+			if len(f) < 2 {
+				skipped++
+				continue
+			}
+			m, ok := clashRuleMatcher(strings.TrimSpace(f[0]), strings.TrimSpace(f[1]))
+			if !ok {
+				skipped++
+				continue
+			}
+			matchers = append(matchers, m)
+		}
+	}
+	return headlessRules(matchers), skipped
+}
+
+// 编译规则集的输出格式
+const (
+	RuleSetSource = "source"
+	RuleSetBinary = "binary"
+)
+
+// CompileRuleSet 拉取 clash 的 rule-provider（yaml 或文本）并按 behavior 编译为 sing-box 规则集，
+// format 为 source 时输出 json，为 binary 时输出 srs。无法转换的规则跳过并返回在警告中。
+func (c *Convert) CompileRuleSet(cxt context.Context, u, behavior, format string) (model.ConfigResult, error) {
+	b, err := httputils.HttpGet(cxt, c.c, u, 1000*1000*10)
+	if err != nil {
+		return model.ConfigResult{}, fmt.Errorf("CompileRuleSet: %w: %w", ErrUpstream, err)
+	}
+	payload, err := providerPayload(b, "")
+	if err != nil {
+		return model.ConfigResult{}, fmt.Errorf("CompileRuleSet: %w: %w", ErrRuleSet, err)
+	}
+	rules, skipped := providerRules(payload, behavior)
+	if len(rules) == 0 {
+		return model.ConfigResult{}, fmt.Errorf("CompileRuleSet: %w: no supported rules", ErrRuleSet)
+	}
+	warnings := []string{}
+	if skipped > 0 {
+		warnings = append(warnings, fmt.Sprintf("ruleset: skipped %d unsupported rules", skipped))
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"version": ruleSetVersion,
+		"rules":   rules,
+	})
+	if err != nil {
+		return model.ConfigResult{}, fmt.Errorf("CompileRuleSet: %w", err)
+	}
+	if format == RuleSetBinary {
+		body, err = compileSRS(body)
+		if err != nil {
+			return model.ConfigResult{}, fmt.Errorf("CompileRuleSet: %w: %w", ErrRuleSet, err)
+		}
+	}
+	return model.ConfigResult{
+		Body:     body,
+		Warnings: warnings,
+		ETag:     ETag(body),
+		Time:     time.Now(),
+	}, nil
+}
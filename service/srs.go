@@ -0,0 +1,33 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sagernet/sing-box/common/srs"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+)
+
+// ruleSetVersion version 2 需要 sing-box 1.10 以上，与支持的最低版本相同
+const ruleSetVersion = C.RuleSetVersion2
+
+// compileSRS 使用 sing-box 的 common/srs 把 source 格式的规则集编译为 srs
+func compileSRS(source []byte) ([]byte, error) {
+	var rs option.PlainRuleSetCompat
+	err := json.Unmarshal(source, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("compileSRS: %w", err)
+	}
+	plain, err := rs.Upgrade()
+	if err != nil {
+		return nil, fmt.Errorf("compileSRS: %w", err)
+	}
+	bw := &bytes.Buffer{}
+	err = srs.Write(bw, plain, rs.Version)
+	if err != nil {
+		return nil, fmt.Errorf("compileSRS: %w", err)
+	}
+	return bw.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sagernet/sing-box/common/srs"
+)
+
+func TestCompileSRS(t *testing.T) {
+	b, err := compileSRS([]byte(`{"version":2,"rules":[{"domain_suffix":["a.com"]},{"ip_cidr":["1.0.1.0/24"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := srs.Read(bytes.NewReader(b), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有一项的 Listable 序列化为单个值
+	jsonEqual(t, rs, `{"version":2,"rules":[{"domain_suffix":"a.com"},{"ip_cidr":"1.0.1.0/24"}]}`)
+
+	_, err = compileSRS([]byte(`{"version":2,"rules":[{"ip_cidr":["a"]}]}`))
+	if err == nil {
+		t.Error("want error for invalid ip_cidr")
+	}
+}
+
+func TestCompileRuleSet(t *testing.T) {
+	files := map[string]string{
+		"/domain.yaml": "payload:\n  - '+.a.com'\n  - '*.b.com'\n  - 'c.com'\n",
+		"/ip.list":     "# ip\n1.0.1.0/24\n\n2.0.0.0/8\n",
+		"/rules.list":  "DOMAIN,a.com\nIP-CIDR,1.1.1.1/32,no-resolve\nUSER-AGENT,curl\n",
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(f))
+	}))
+	defer s.Close()
+	c := NewConvert(s.Client(), slog.Default())
+
+	tests := []struct {
+		name     string
+		path     string
+		behavior string
+		want     string
+		warnings int
+		wantErr  error
+	}{
+		{
+			name:     "domain wildcard",
+			path:     "/domain.yaml",
+			behavior: "domain",
+			want:     `{"version":2,"rules":[{"domain_suffix":["a.com"]},{"domain_regex":["^[^.]+\\.b\\.com$"]},{"domain":["c.com"]}]}`,
+		},
+		{
+			name:     "ipcidr",
+			path:     "/ip.list",
+			behavior: "ipcidr",
+			want:     `{"version":2,"rules":[{"ip_cidr":["1.0.1.0/24","2.0.0.0/8"]}]}`,
+		},
+		{
+			name:     "classical skipped",
+			path:     "/rules.list",
+			behavior: "classical",
+			want:     `{"version":2,"rules":[{"domain":["a.com"]},{"ip_cidr":["1.1.1.1/32"]}]}`,
+			warnings: 1,
+		},
+		{
+			name:     "no supported rules",
+			path:     "/ip.list",
+			behavior: "classical",
+			wantErr:  ErrRuleSet,
+		},
+		{
+			name:     "upstream",
+			path:     "/missing",
+			behavior: "domain",
+			wantErr:  ErrUpstream,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := c.CompileRuleSet(context.Background(), s.URL+tt.path, tt.behavior, RuleSetSource)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonEqual(t, jsonMap(t, string(r.Body)), tt.want)
+			if len(r.Warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", r.Warnings, tt.warnings)
+			}
+			if r.ETag != ETag(r.Body) {
+				t.Errorf("etag = %s", r.ETag)
+			}
+		})
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		r, err := c.CompileRuleSet(context.Background(), s.URL+"/domain.yaml", "domain", RuleSetBinary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, err := srs.Read(bytes.NewReader(r.Body), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs.Version != ruleSetVersion || len(rs.Options.Rules) != 3 {
+			t.Errorf("got version %d with %d rules", rs.Version, len(rs.Options.Rules))
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xmdhs/clash2sfa/model"
+	"github.com/xmdhs/clash2sfa/store"
+)
+
+// Stale 上游订阅出错时返回最后一次成功的配置
+type Stale struct {
+	s      *store.FileStale
+	maxAge time.Duration
+}
+
+func NewStale(s *store.FileStale, maxAge time.Duration) *Stale {
+	return &Stale{
+		s:      s,
+		maxAge: maxAge,
+	}
+}
+
+func (s *Stale) Save(cxt context.Context, key string, c model.ConfigResult) error {
+	err := s.s.Put(cxt, key, c)
+	if err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	return nil
+}
+
+// Load 读取旧配置，超过 maxAge 的视为不存在
+func (s *Stale) Load(cxt context.Context, key string) (model.ConfigResult, bool) {
+	c, err := s.s.Get(cxt, key)
+	if err != nil {
+		return c, false
+	}
+	if s.maxAge > 0 && time.Since(c.Time) > s.maxAge {
+		return c, false
+	}
+	return c, true
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+// headerTransport 记录拉取订阅时的响应头，httputils.GetAny 不会返回这些信息
+type headerTransport struct {
+	base    http.RoundTripper
+	l       sync.Mutex
+	headers []urlHeader
+}
+
+type urlHeader struct {
+	url    string
+	header http.Header
+}
+
+func (h *headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rep, err := h.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if rep.StatusCode == http.StatusOK {
+		h.l.Lock()
+		h.headers = append(h.headers, urlHeader{url: r.URL.String(), header: rep.Header.Clone()})
+		h.l.Unlock()
+	}
+	return rep, nil
+}
+
+// subInfo 按订阅填写的顺序合并，名称等取第一个订阅的
+func (h *headerTransport) subInfo(urls []string) model.SubInfo {
+	index := func(u string) int {
+		i := slices.Index(urls, u)
+		if i == -1 {
+			return len(urls)
+		}
+		return i
+	}
+
+	h.l.Lock()
+	headers := slices.Clone(h.headers)
+	h.l.Unlock()
+	slices.SortStableFunc(headers, func(a, b urlHeader) int {
+		return index(a.url) - index(b.url)
+	})
+
+	s := model.SubInfo{}
+	for _, v := range headers {
+		s.Add(v.header)
+	}
+	return s
+}
+
+func withHeaderTransport(c *http.Client) (*http.Client, *headerTransport) {
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &headerTransport{base: base}
+	nc := *c
+	nc.Transport = t
+	return &nc, t
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/xmdhs/clash2singbox/model"
+)
+
+// TemplateEntry 适用于 [Min, Max] 版本范围的默认模板
+type TemplateEntry struct {
+	Min  model.SingBoxVer
+	Max  model.SingBoxVer
+	Name string
+}
+
+var defaultTemplates = []TemplateEntry{
+	{Min: model.SING110, Max: model.SING110, Name: "config.json.template"},
+	{Min: model.SING111, Max: model.SING111, Name: "config.json-1.11.0+.template"},
+	{Min: model.SING112, Max: model.SINGLATEST, Name: "config.json-1.12.0+.template"},
+}
+
+// Templates 根据 sing-box 版本选择默认模板，dir 中的同名文件优先于内置的模板
+type Templates struct {
+	embed   fs.FS
+	dir     fs.FS
+	entries []TemplateEntry
+}
+
+func NewTemplates(embed fs.FS, dir string) *Templates {
+	t := &Templates{
+		embed:   embed,
+		entries: defaultTemplates,
+	}
+	if dir != "" {
+		t.dir = os.DirFS(dir)
+	}
+	return t
+}
+
+func (t *Templates) Get(v model.SingBoxVer) ([]byte, error) {
+	name := t.entries[len(t.entries)-1].Name
+	for _, e := range t.entries {
+		if v >= e.Min && v <= e.Max {
+			name = e.Name
+			break
+		}
+	}
+	if t.dir != nil {
+		b, err := fs.ReadFile(t.dir, name)
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Get: %w", err)
+		}
+	}
+	b, err := fs.ReadFile(t.embed, name)
+	if err != nil {
+		return nil, fmt.Errorf("Get: %w", err)
+	}
+	return b, nil
+}
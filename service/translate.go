@@ -0,0 +1,409 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xmdhs/clash2sfa/utils"
+	"github.com/xmdhs/clash2singbox/model"
+)
+
+// downgradeTemplate 把按最新格式编写的模板改写为旧版本 sing-box 能识别的格式，
+// 已经是旧格式的内容不会改动，返回无法表示而被删除的内容
+func downgradeTemplate(config map[string]any, ver model.SingBoxVer) []string {
+	warnings := []string{}
+	if ver >= model.SING112 {
+		return warnings
+	}
+	warnings = append(warnings, downgradeDNSServers(config)...)
+	warnings = append(warnings, downgradeDomainResolver(config)...)
+	if ver >= model.SING111 {
+		return warnings
+	}
+	warnings = append(warnings, downgradeRouteRules(config)...)
+	warnings = append(warnings, downgradeDNSRules(config)...)
+	downgradeInboundAddress(config)
+	return warnings
+}
+
+var rcodeMap = map[string]string{
+	"NOERROR":  "success",
+	"FORMERR":  "format_error",
+	"SERVFAIL": "server_failure",
+	"NXDOMAIN": "name_error",
+	"NOTIMP":   "not_implemented",
+	"REFUSED":  "refused",
+}
+
+// downgradeDNSServers 1.12 的 type/server 格式的 dns 服务器改为 address 格式
+func downgradeDNSServers(config map[string]any) []string {
+	warnings := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	servers := utils.AnyGet[[]any](dns, "servers")
+	if len(servers) == 0 {
+		return warnings
+	}
+	newServers := make([]any, 0, len(servers))
+	for _, v := range servers {
+		server, ok := v.(map[string]any)
+		if !ok {
+			newServers = append(newServers, v)
+			continue
+		}
+		t := utils.AnyGet[string](server, "type")
+		if t == "" {
+			newServers = append(newServers, server)
+			continue
+		}
+		tag := utils.AnyGet[string](server, "tag")
+		host := utils.AnyGet[string](server, "server")
+		// 从模板读取的端口为 float64，转换 clash dns 时生成的为 int
+		switch port := server["server_port"].(type) {
+		case float64:
+			host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		case int:
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		path := utils.AnyGet[string](server, "path")
+		if path == "" {
+			path = "/dns-query"
+		}
+
+		var address string
+		switch t {
+		case "udp":
+			address = host
+		case "tcp", "tls", "quic":
+			address = t + "://" + host
+		case "https", "h3":
+			address = t + "://" + host + path
+		case "local":
+			address = "local"
+		case "dhcp":
+			address = "dhcp://auto"
+			if i := utils.AnyGet[string](server, "interface"); i != "" {
+				address = "dhcp://" + i
+			}
+		case "fakeip":
+			address = "fakeip"
+			fakeip := map[string]any{"enabled": true}
+			for _, k := range []string{"inet4_range", "inet6_range"} {
+				if r, ok := server[k]; ok {
+					fakeip[k] = r
+				}
+			}
+			utils.AnySet(&dns, fakeip, "fakeip")
+		default:
+			warnings = append(warnings, fmt.Sprintf("dns.servers: %v 类型的服务器 %v 无法在旧版本中表示，已删除", t, tag))
+			continue
+		}
+		if _, ok := server["tls"]; ok {
+			warnings = append(warnings, fmt.Sprintf("dns.servers: 服务器 %v 的 tls 设置无法在旧版本中表示，已删除", tag))
+		}
+
+		ns := map[string]any{
+			"tag":     tag,
+			"address": address,
+		}
+		for _, k := range []string{"detour", "strategy", "client_subnet"} {
+			if v, ok := server[k]; ok {
+				ns[k] = v
+			}
+		}
+		if r := domainResolverServer(server["domain_resolver"]); r != "" {
+			ns["address_resolver"] = r
+		}
+		newServers = append(newServers, ns)
+	}
+
+	// predefined 规则改为指向 rcode:// 服务器
+	rules := utils.AnyGet[[]any](dns, "rules")
+	newRules := make([]any, 0, len(rules))
+	rcodeServer := map[string]struct{}{}
+	for i, v := range rules {
+		rule, ok := v.(map[string]any)
+		if !ok || utils.AnyGet[string](rule, "action") != "predefined" {
+			newRules = append(newRules, v)
+			continue
+		}
+		if _, ok := rule["answer"]; ok {
+			warnings = append(warnings, fmt.Sprintf("dns.rules[%d]: predefined 的 answer 无法在旧版本中表示，已删除", i))
+			continue
+		}
+		rcode := utils.AnyGet[string](rule, "rcode")
+		if rcode == "" {
+			rcode = "NOERROR"
+		}
+		name, ok := rcodeMap[rcode]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("dns.rules[%d]: 未知的 rcode %v，已删除", i, rcode))
+			continue
+		}
+		tag := "rcode-" + name
+		if _, ok := rcodeServer[tag]; !ok {
+			rcodeServer[tag] = struct{}{}
+			newServers = append(newServers, map[string]any{
+				"tag":     tag,
+				"address": "rcode://" + name,
+			})
+		}
+		delete(rule, "action")
+		delete(rule, "rcode")
+		rule["server"] = tag
+		newRules = append(newRules, rule)
+	}
+
+	utils.AnySet(&dns, newServers, "servers")
+	if rules != nil {
+		utils.AnySet(&dns, newRules, "rules")
+	}
+	return warnings
+}
+
+// downgradeDomainResolver route.default_domain_resolver 和出站的 domain_resolver
+// 改为按出站匹配的 dns 规则
+func downgradeDomainResolver(config map[string]any) []string {
+	warnings := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	route := utils.AnyGet[map[string]any](config, "route")
+	resolverRules := []any{}
+
+	for _, v := range utils.AnyGet[[]any](config, "outbounds") {
+		out, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		r, ok := out["domain_resolver"]
+		if !ok {
+			continue
+		}
+		delete(out, "domain_resolver")
+		if server := domainResolverServer(r); server != "" {
+			resolverRules = append(resolverRules, map[string]any{
+				"outbound": []any{utils.AnyGet[string](out, "tag")},
+				"server":   server,
+			})
+		}
+	}
+	if r, ok := route["default_domain_resolver"]; ok {
+		delete(route, "default_domain_resolver")
+		if server := domainResolverServer(r); server != "" {
+			resolverRules = append(resolverRules, map[string]any{
+				"outbound": []any{"any"},
+				"server":   server,
+			})
+		}
+	}
+	if len(resolverRules) == 0 {
+		return warnings
+	}
+	if dns == nil {
+		warnings = append(warnings, "route.default_domain_resolver: 模板中没有 dns，已删除")
+		return warnings
+	}
+	rules := utils.AnyGet[[]any](dns, "rules")
+	utils.AnySet(&dns, append(resolverRules, rules...), "rules")
+	return warnings
+}
+
+func domainResolverServer(r any) string {
+	switch r := r.(type) {
+	case string:
+		return r
+	case map[string]any:
+		return utils.AnyGet[string](r, "server")
+	}
+	return ""
+}
+
+// downgradeRouteRules 1.11 的规则动作改为 1.10 的 dns-out、block 出站和入站的 sniff 字段
+func downgradeRouteRules(config map[string]any) []string {
+	warnings := []string{}
+	route := utils.AnyGet[map[string]any](config, "route")
+	rules := utils.AnyGet[[]any](route, "rules")
+	if len(rules) == 0 {
+		return warnings
+	}
+	inbounds := utils.AnyGet[[]any](config, "inbounds")
+	newRules := make([]any, 0, len(rules))
+	needOut := map[string]string{}
+
+	for i, v := range rules {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			newRules = append(newRules, v)
+			continue
+		}
+		action := utils.AnyGet[string](rule, "action")
+		switch action {
+		case "":
+			newRules = append(newRules, rule)
+		case "route":
+			delete(rule, "action")
+			newRules = append(newRules, rule)
+		case "sniff":
+			if hasExtraField(rule, "action") {
+				warnings = append(warnings, fmt.Sprintf("route.rules[%d]: sniff 的匹配条件和参数无法在旧版本中表示，改为所有入站开启 sniff", i))
+			}
+			setInbounds(inbounds, "sniff", true)
+		case "resolve":
+			strategy := utils.AnyGet[string](rule, "strategy")
+			if strategy == "" {
+				strategy = "prefer_ipv4"
+			}
+			if hasExtraField(rule, "action", "strategy") {
+				warnings = append(warnings, fmt.Sprintf("route.rules[%d]: resolve 的匹配条件和参数无法在旧版本中表示，改为所有入站的 domain_strategy", i))
+			}
+			setInbounds(inbounds, "domain_strategy", strategy)
+		case "hijack-dns":
+			delete(rule, "action")
+			rule["outbound"] = "dns-out"
+			needOut["dns-out"] = "dns"
+			newRules = append(newRules, rule)
+		case "reject":
+			delete(rule, "action")
+			delete(rule, "method")
+			delete(rule, "no_drop")
+			rule["outbound"] = "block"
+			needOut["block"] = "block"
+			newRules = append(newRules, rule)
+		default:
+			warnings = append(warnings, fmt.Sprintf("route.rules[%d]: %v 动作无法在旧版本中表示，已删除", i, action))
+		}
+	}
+	utils.AnySet(&route, newRules, "rules")
+
+	outbounds := utils.AnyGet[[]any](config, "outbounds")
+	for _, v := range outbounds {
+		delete(needOut, utils.AnyGet[string](v, "tag"))
+	}
+	for _, tag := range []string{"dns-out", "block"} {
+		if t, ok := needOut[tag]; ok {
+			outbounds = append(outbounds, map[string]any{
+				"type": t,
+				"tag":  tag,
+			})
+		}
+	}
+	utils.AnySet(&config, outbounds, "outbounds")
+	return warnings
+}
+
+func hasExtraField(m map[string]any, fields ...string) bool {
+	for k := range m {
+		if !slices.Contains(fields, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func setInbounds(inbounds []any, key string, value any) {
+	for _, v := range inbounds {
+		in, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := in[key]; !ok {
+			in[key] = value
+		}
+	}
+}
+
+// downgradeDNSRules 1.10 的 dns 规则没有动作，只能指定 server
+func downgradeDNSRules(config map[string]any) []string {
+	warnings := []string{}
+	dns := utils.AnyGet[map[string]any](config, "dns")
+	rules := utils.AnyGet[[]any](dns, "rules")
+	if len(rules) == 0 {
+		return warnings
+	}
+	servers := utils.AnyGet[[]any](dns, "servers")
+	newRules := make([]any, 0, len(rules))
+	hasRefused := false
+
+	for i, v := range rules {
+		rule, ok := v.(map[string]any)
+		if !ok {
+			newRules = append(newRules, v)
+			continue
+		}
+		action := utils.AnyGet[string](rule, "action")
+		switch action {
+		case "":
+			newRules = append(newRules, rule)
+		case "route":
+			delete(rule, "action")
+			newRules = append(newRules, rule)
+		case "reject":
+			delete(rule, "action")
+			delete(rule, "method")
+			delete(rule, "no_drop")
+			rule["server"] = "rcode-refused"
+			hasRefused = true
+			newRules = append(newRules, rule)
+		default:
+			warnings = append(warnings, fmt.Sprintf("dns.rules[%d]: %v 动作无法在旧版本中表示，已删除", i, action))
+		}
+	}
+	if hasRefused {
+		servers = append(servers, map[string]any{
+			"tag":     "rcode-refused",
+			"address": "rcode://refused",
+		})
+		utils.AnySet(&dns, servers, "servers")
+	}
+	utils.AnySet(&dns, newRules, "rules")
+	return warnings
+}
+
+var addressFields = map[string][2]string{
+	"address":               {"inet4_address", "inet6_address"},
+	"route_address":         {"inet4_route_address", "inet6_route_address"},
+	"route_exclude_address": {"inet4_route_exclude_address", "inet6_route_exclude_address"},
+}
+
+// downgradeInboundAddress tun 入站的 address 按 ip 版本拆分为 inet4_address 和 inet6_address
+func downgradeInboundAddress(config map[string]any) {
+	for _, v := range utils.AnyGet[[]any](config, "inbounds") {
+		in, ok := v.(map[string]any)
+		if !ok || utils.AnyGet[string](in, "type") != "tun" {
+			continue
+		}
+		for field, newField := range addressFields {
+			var list []any
+			switch a := in[field].(type) {
+			case []any:
+				list = a
+			case string:
+				list = []any{a}
+			default:
+				continue
+			}
+			delete(in, field)
+			var v4, v6 []any
+			for _, a := range list {
+				s, ok := a.(string)
+				if !ok {
+					continue
+				}
+				p, err := netip.ParsePrefix(strings.TrimSpace(s))
+				if err == nil && p.Addr().Is6() {
+					v6 = append(v6, s)
+				} else {
+					v4 = append(v4, s)
+				}
+			}
+			if len(v4) != 0 {
+				in[newField[0]] = v4
+			}
+			if len(v6) != 0 {
+				in[newField[1]] = v6
+			}
+		}
+	}
+}
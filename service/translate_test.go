@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/xmdhs/clash2singbox/model"
+)
+
+func jsonMap(t *testing.T, s string) map[string]any {
+	t.Helper()
+	m := map[string]any{}
+	err := json.Unmarshal([]byte(s), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// jsonEqual 按 json 比较，忽略 int 和 float64 等类型的差异
+func jsonEqual(t *testing.T, got any, want string) {
+	t.Helper()
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g, w any
+	if err := json.Unmarshal(b, &g); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+}
+
+func TestDowngradeTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		ver      model.SingBoxVer
+		config   string
+		want     string
+		warnings int
+	}{
+		{
+			name:   "latest unchanged",
+			ver:    model.SING112,
+			config: `{"dns":{"servers":[{"type":"udp","tag":"a","server":"1.1.1.1"}]}}`,
+			want:   `{"dns":{"servers":[{"type":"udp","tag":"a","server":"1.1.1.1"}]}}`,
+		},
+		{
+			name: "typed dns servers",
+			ver:  model.SING111,
+			config: `{"dns":{"servers":[
+				{"type":"udp","tag":"udp","server":"1.1.1.1","server_port":5353,"detour":"proxy"},
+				{"type":"https","tag":"doh","server":"dns.google","domain_resolver":"local"},
+				{"type":"local","tag":"local"},
+				{"type":"fakeip","tag":"fakeip","inet4_range":"198.18.0.0/15"},
+				{"type":"dhcp","tag":"dhcp","interface":"en0"}
+			]}}`,
+			want: `{"dns":{"fakeip":{"enabled":true,"inet4_range":"198.18.0.0/15"},"servers":[
+				{"tag":"udp","address":"1.1.1.1:5353","detour":"proxy"},
+				{"tag":"doh","address":"https://dns.google/dns-query","address_resolver":"local"},
+				{"tag":"local","address":"local"},
+				{"tag":"fakeip","address":"fakeip"},
+				{"tag":"dhcp","address":"dhcp://en0"}
+			]}}`,
+		},
+		{
+			name: "predefined becomes rcode server",
+			ver:  model.SING111,
+			config: `{"dns":{"servers":[{"type":"local","tag":"local"}],"rules":[
+				{"query_type":["HTTPS"],"action":"predefined"},
+				{"domain":["a.com"],"action":"predefined","rcode":"NXDOMAIN"},
+				{"domain":["b.com"],"action":"predefined","answer":["b.com. IN A 1.1.1.1"]}
+			]}}`,
+			want: `{"dns":{"servers":[
+				{"tag":"local","address":"local"},
+				{"tag":"rcode-success","address":"rcode://success"},
+				{"tag":"rcode-name_error","address":"rcode://name_error"}
+			],"rules":[
+				{"query_type":["HTTPS"],"server":"rcode-success"},
+				{"domain":["a.com"],"server":"rcode-name_error"}
+			]}}`,
+			warnings: 1,
+		},
+		{
+			name: "domain resolver becomes dns rules",
+			ver:  model.SING111,
+			config: `{"dns":{"rules":[{"domain":["a.com"],"server":"remote"}]},
+				"outbounds":[{"type":"vless","tag":"node","domain_resolver":{"server":"local","strategy":"ipv4_only"}}],
+				"route":{"default_domain_resolver":"local"}}`,
+			want: `{"dns":{"rules":[
+				{"outbound":["node"],"server":"local"},
+				{"outbound":["any"],"server":"local"},
+				{"domain":["a.com"],"server":"remote"}
+			]},"outbounds":[{"type":"vless","tag":"node"}],"route":{}}`,
+		},
+		{
+			name: "rule actions for 1.10",
+			ver:  model.SING110,
+			config: `{"inbounds":[{"type":"tun","address":["172.19.0.1/30","fdfe:dcba:9876::1/126"]},{"type":"mixed"}],
+				"outbounds":[{"type":"direct","tag":"direct"}],
+				"route":{"rules":[
+					{"action":"sniff"},
+					{"action":"resolve","strategy":"ipv4_only"},
+					{"protocol":"dns","action":"hijack-dns"},
+					{"domain":["ad.com"],"action":"reject","method":"drop"},
+					{"domain":["a.com"],"action":"route","outbound":"direct"},
+					{"action":"route-options","udp_timeout":"5m"}
+				]},
+				"dns":{"rules":[{"domain":["ad.com"],"action":"reject"}]}}`,
+			want: `{"inbounds":[
+					{"type":"tun","inet4_address":["172.19.0.1/30"],"inet6_address":["fdfe:dcba:9876::1/126"],"sniff":true,"domain_strategy":"ipv4_only"},
+					{"type":"mixed","sniff":true,"domain_strategy":"ipv4_only"}
+				],
+				"outbounds":[{"type":"direct","tag":"direct"},{"type":"dns","tag":"dns-out"},{"type":"block","tag":"block"}],
+				"route":{"rules":[
+					{"protocol":"dns","outbound":"dns-out"},
+					{"domain":["ad.com"],"outbound":"block"},
+					{"domain":["a.com"],"outbound":"direct"}
+				]},
+				"dns":{"servers":[{"tag":"rcode-refused","address":"rcode://refused"}],"rules":[{"domain":["ad.com"],"server":"rcode-refused"}]}}`,
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := jsonMap(t, tt.config)
+			warnings := downgradeTemplate(config, tt.ver)
+			jsonEqual(t, config, tt.want)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+var idReg = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+
+// FileStore 每个 profile 保存为目录下的一个 json 文件
+type FileStore struct {
+	dir string
+	l   sync.RWMutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileStore: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) (string, error) {
+	if !idReg.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) Get(ctx context.Context, id string) (model.Profile, error) {
+	var p model.Profile
+	path, err := f.path(id)
+	if err != nil {
+		return p, fmt.Errorf("Get: %w", err)
+	}
+	f.l.RLock()
+	b, err := os.ReadFile(path)
+	f.l.RUnlock()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return p, fmt.Errorf("Get: %w", ErrNotFound)
+		}
+		return p, fmt.Errorf("Get: %w", err)
+	}
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return p, fmt.Errorf("Get: %w", err)
+	}
+	return p, nil
+}
+
+func (f *FileStore) Put(ctx context.Context, p model.Profile) error {
+	_, err := f.path(p.ID)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	err = writeFile(f.dir, p.ID, b)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	err = os.Remove(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("Delete: %w", ErrNotFound)
+		}
+		return fmt.Errorf("Delete: %w", err)
+	}
+	return nil
+}
+
+// writeFile 先写临时文件再重命名，避免写入一半时被读到
+func writeFile(dir, name string, b []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name+".json"))
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+// FileStale 按参数哈希保存最后一次成功生成的配置
+type FileStale struct {
+	dir string
+}
+
+func NewFileStale(dir string) (*FileStale, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileStale: %w", err)
+	}
+	return &FileStale{dir: dir}, nil
+}
+
+func (f *FileStale) Get(ctx context.Context, key string) (model.ConfigResult, error) {
+	var c model.ConfigResult
+	if !idReg.MatchString(key) {
+		return c, fmt.Errorf("Get: %w", ErrNotFound)
+	}
+	b, err := os.ReadFile(filepath.Join(f.dir, key+".json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return c, fmt.Errorf("Get: %w", ErrNotFound)
+		}
+		return c, fmt.Errorf("Get: %w", err)
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return c, fmt.Errorf("Get: %w", err)
+	}
+	return c, nil
+}
+
+func (f *FileStale) Put(ctx context.Context, key string, c model.ConfigResult) error {
+	if !idReg.MatchString(key) {
+		return fmt.Errorf("Put: %w", ErrNotFound)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	err = writeFile(f.dir, key, b)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	return nil
+}
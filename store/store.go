@@ -0,0 +1,17 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xmdhs/clash2sfa/model"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ProfileStore 短链接参数的存储，默认实现为 FileStore，可替换为数据库等
+type ProfileStore interface {
+	Get(ctx context.Context, id string) (model.Profile, error)
+	Put(ctx context.Context, p model.Profile) error
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache 有过期时间和数量上限的 LRU 缓存，size 或 ttl 不大于 0 时不缓存
+type Cache[V any] struct {
+	size int
+	ttl  time.Duration
+	l    sync.Mutex
+	m    map[string]*list.Element
+	ll   *list.List
+}
+
+type cacheItem[V any] struct {
+	key string
+	v   V
+	t   time.Time
+}
+
+func NewCache[V any](size int, ttl time.Duration) *Cache[V] {
+	return &Cache[V]{
+		size: size,
+		ttl:  ttl,
+		m:    map[string]*list.Element{},
+		ll:   list.New(),
+	}
+}
+
+func (c *Cache[V]) Get(key string) (V, bool) {
+	var v V
+	if c.size <= 0 || c.ttl <= 0 {
+		return v, false
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	e, ok := c.m[key]
+	if !ok {
+		return v, false
+	}
+	item := e.Value.(*cacheItem[V])
+	if time.Since(item.t) > c.ttl {
+		c.ll.Remove(e)
+		delete(c.m, key)
+		return v, false
+	}
+	c.ll.MoveToFront(e)
+	return item.v, true
+}
+
+func (c *Cache[V]) Set(key string, v V) {
+	if c.size <= 0 || c.ttl <= 0 {
+		return
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.m[key]; ok {
+		e.Value = &cacheItem[V]{key: key, v: v, t: time.Now()}
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[key] = c.ll.PushFront(&cacheItem[V]{key: key, v: v, t: time.Now()})
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.m, e.Value.(*cacheItem[V]).key)
+	}
+}
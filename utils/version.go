@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"io/fs"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
@@ -22,8 +22,25 @@ func GetSingBoxVersion(r *http.Request) model.SingBoxVer {
 	if err != nil {
 		return model.SINGLATEST
 	}
-	minor := v.Minor()
+	return minorToVer(v.Minor())
+}
+
+// ParseSingBoxVersion 解析 1.11、1.11.0 这样的版本号，latest 为最新版本
+func ParseSingBoxVersion(s string) (model.SingBoxVer, error) {
+	if s == "latest" {
+		return model.SINGLATEST, nil
+	}
+	v, err := semver.NewVersion(s)
+	if err != nil {
+		return 0, fmt.Errorf("ParseSingBoxVersion: %w", err)
+	}
+	if v.Major() != 1 {
+		return 0, fmt.Errorf("ParseSingBoxVersion: unsupported version %v", s)
+	}
+	return minorToVer(v.Minor()), nil
+}
 
+func minorToVer(minor uint64) model.SingBoxVer {
 	switch {
 	case minor <= 10:
 		return model.SING110
@@ -36,19 +53,6 @@ func GetSingBoxVersion(r *http.Request) model.SingBoxVer {
 	}
 }
 
-func GetConfig(v model.SingBoxVer, configFs fs.FS) []byte {
-	switch {
-	case v >= model.SING112:
-		return FsReadAll(configFs, "config.json-1.12.0+.template")
-	case v >= model.SING111:
-		return FsReadAll(configFs, "config.json-1.11.0+.template")
-	case v >= model.SING110:
-		return FsReadAll(configFs, "config.json.template")
-	default:
-		return FsReadAll(configFs, "config.json-1.12.0+.template")
-	}
-}
-
 // IsBrowser 检查 User-Agent 是否为浏览器
 func IsBrowser(userAgent string) bool {
 	ua := strings.ToLower(userAgent)